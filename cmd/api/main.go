@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"goAuth/internal/api/handlers"
 	"goAuth/internal/api/interceptors"
+	"goAuth/internal/repositories/mongodb"
 	"goAuth/pkg/utils"
 	pb "goAuth/proto/gen"
 	"log"
 	"net"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"google.golang.org/grpc"
@@ -24,14 +27,88 @@ func init() {
 }
 
 func main() {
+	err := mongodb.EnsureAuditIndexes(context.Background())
+	if err != nil {
+		log.Fatalf("Error setting up audit log: %v", err)
+	}
+
+	auditWriter, err := mongodb.NewAuditWriter()
+	if err != nil {
+		log.Fatalf("Error setting up audit log: %v", err)
+	}
+	go auditWriter.Run()
+
+	err = mongodb.EnsurePasskeyIndexes(context.Background())
+	if err != nil {
+		log.Fatalf("Error setting up passkeys: %v", err)
+	}
+
+	migrated, err := mongodb.MigrateUserRoles(context.Background())
+	if err != nil {
+		log.Fatalf("Error migrating user roles: %v", err)
+	}
+	if migrated > 0 {
+		log.Printf("Migrated %d users to multiple roles", migrated)
+	}
+
+	err = mongodb.SeedDefaultRoles(context.Background())
+	if err != nil {
+		log.Fatalf("Error setting up roles: %v", err)
+	}
+	err = mongodb.RefreshRoleRegistry(context.Background())
+	if err != nil {
+		log.Fatalf("Error loading roles: %v", err)
+	}
+
+	rpcPolicy, err := interceptors.LoadRPCPolicy()
+	if err != nil {
+		log.Fatalf("Error loading rpc policy: %v", err)
+	}
+
+	err = utils.LoadAccessPolicy()
+	if err != nil {
+		log.Fatalf("Error loading access policy: %v", err)
+	}
+
+	relationSchema, err := utils.LoadRelationSchema()
+	if err != nil {
+		log.Fatalf("Error loading relation schema: %v", err)
+	}
+
+	rateLimiter, rateLimits := rateLimitConfig()
+
+	// Always kept in mongodb, so unknown usernames do the same database work as real accounts on every replica
+	unknownUserLockouts, err := mongodb.NewMongoUnknownUserLockouts(context.Background())
+	if err != nil {
+		log.Fatalf("Error setting up unknown user lockouts: %v", err)
+	}
+
 	s := grpc.NewServer(
-		grpc.UnaryInterceptor(interceptors.AuthenticationInterceptor),
+		grpc.ChainUnaryInterceptor(
+			interceptors.NewAuditInterceptor(auditWriter),
+			interceptors.NewAuthenticationInterceptor(rpcPolicy),
+			interceptors.NewRateLimitInterceptor(rateLimiter, rateLimits),
+		),
 	)
 
 	// Triggers every 2 minutes and cleans up all the expired tokens
 	go utils.JwtStore.CleanUpExpiredTokens()
+	go utils.PasskeySessions.CleanUpExpiredSessions()
+	go utils.AuthorizationCache.CleanUpExpiredEntries()
+	go purgeDeletedUsers()
+	go refreshRoles()
 
-	pb.RegisterAuthServiceServer(s, &handlers.Server{})
+	pb.RegisterAuthServiceServer(s, &handlers.Server{
+		Relations:           utils.NewRelationEngine(relationSchema, tupleStore()),
+		UnknownUserLockouts: unknownUserLockouts,
+		Accounts:            mongodb.MongoAccounts{},
+	})
+
+	// Every rpc must be declared public, authenticated or restricted before the server accepts calls
+	err = rpcPolicy.Check(s.GetServiceInfo())
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	reflection.Register(s)
 
@@ -52,3 +129,69 @@ func main() {
 		return
 	}
 }
+
+// purgeDeletedUsers hard deletes users once they have been soft deleted for longer than USER_PURGE_AFTER
+func purgeDeletedUsers() {
+	for {
+		purged, err := mongodb.PurgeDeletedUsers(context.Background(), time.Now().Add(-utils.UserPurgeAfter()))
+		if err != nil {
+			log.Printf("Error purging deleted users: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d deleted users", purged)
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
+
+// refreshRoles reloads roles periodically so that changes made through other replicas apply here too
+func refreshRoles() {
+	for {
+		time.Sleep(utils.RoleRefreshInterval())
+
+		err := mongodb.RefreshRoleRegistry(context.Background())
+		if err != nil {
+			log.Printf("Error refreshing roles: %v", err)
+		}
+	}
+}
+
+// tupleStore returns the relation tuple backend selected by RELATION_BACKEND, "memory" by default or "mongodb"
+func tupleStore() utils.TupleStore {
+	if os.Getenv("RELATION_BACKEND") == "mongodb" {
+		store, err := mongodb.NewMongoTupleStore(context.Background())
+		if err != nil {
+			log.Fatalf("Error setting up relation tuples: %v", err)
+		}
+		return store
+	}
+
+	store := utils.NewMemoryTupleStore()
+	go store.CleanUpDeletedTuples()
+	return store
+}
+
+// rateLimitConfig reads RATE_LIMITS (falling back to utils.DefaultRateLimits) and RATE_LIMIT_BACKEND,
+// which is "memory" by default or "mongodb" to share limits between replicas
+func rateLimitConfig() (utils.RateLimiter, map[string]utils.RateLimitRule) {
+	rules := utils.DefaultRateLimits
+	if spec := os.Getenv("RATE_LIMITS"); spec != "" {
+		parsed, err := utils.ParseRateLimits(spec)
+		if err != nil {
+			log.Fatalf("Invalid RATE_LIMITS: %v", err)
+		}
+		rules = parsed
+	}
+
+	if os.Getenv("RATE_LIMIT_BACKEND") == "mongodb" {
+		limiter, err := mongodb.NewMongoRateLimiter(context.Background())
+		if err != nil {
+			log.Fatalf("Error setting up rate limiter: %v", err)
+		}
+		return limiter, rules
+	}
+
+	limiter := utils.NewMemoryRateLimiter()
+	go limiter.CleanUpIdleBuckets()
+	return limiter, rules
+}
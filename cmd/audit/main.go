@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"goAuth/internal/repositories/mongodb"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func init() {
+	// Load .env file if it exists (optional for Docker deployments)
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+}
+
+// Command audit verifies the audit log hash chain: go run ./cmd/audit verify
+// It exits with status 1 if the chain is broken, so it can run from cron or CI
+func main() {
+	if len(os.Args) != 2 || os.Args[1] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: audit verify")
+		os.Exit(2)
+	}
+
+	report, err := mongodb.VerifyAuditChain(context.Background())
+	if err != nil {
+		log.Fatalf("Error verifying audit chain: %v", err)
+	}
+
+	if report.Reason != "" {
+		fmt.Printf("Audit chain BROKEN at seq %d (id %s): %s\n", report.BrokenSeq, report.BrokenId, report.Reason)
+		fmt.Printf("Last intact event is seq %d (hash %s)\n", report.HeadSeq, report.HeadHash)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Audit chain OK: %d events checked, head seq %d (hash %s)\n", report.Checked, report.HeadSeq, report.HeadHash)
+}
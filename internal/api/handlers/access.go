@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const resourceTypeUser = "user"
+
+// CheckAccess evaluates the access rules for an action so other services can share them. Checking on behalf
+// of another user requires the access.check permission
+func (s *Server) CheckAccess(ctx context.Context, req *pb.CheckAccessRequest) (*pb.CheckAccessResponse, error) {
+	if req.GetAction() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Action is required")
+	}
+
+	subject := utils.CallerSubject(ctx)
+	if req.GetSubjectId() != "" && req.GetSubjectId() != subject["id"] {
+		err := utils.RequirePermission(ctx, utils.PermAccessCheck)
+		if err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+
+		subjectUser, err := mongodb.GetUserById(ctx, req.GetSubjectId())
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if subjectUser == nil || subjectUser.AccountStatus() == models.UserStatusDeleted || !visibleToCaller(ctx, subjectUser) {
+			return nil, status.Error(codes.NotFound, "Subject not found")
+		}
+		subject = utils.AccessSubject(subjectUser.Id, subjectUser.Username, utils.CallerOrganization(ctx), scopedRoles(ctx, subjectUser))
+	}
+
+	resource, err := accessResource(ctx, utils.CallerOrganization(ctx), req.GetResourceType(), req.GetResourceId(), req.GetResourceAttributes())
+	if err != nil {
+		return nil, err
+	}
+	if resource == nil {
+		return nil, status.Error(codes.NotFound, "Resource not found")
+	}
+
+	decision := utils.AccessPolicies.Evaluate(utils.AccessInput{
+		Subject:  subject,
+		Action:   req.GetAction(),
+		Resource: resource,
+		Request:  contextAttributes(req.GetContext()),
+	})
+	utils.AddAuditDetail(ctx, "action", req.GetAction())
+	utils.AddAuditDetail(ctx, "allowed", strconv.FormatBool(decision.Allowed))
+
+	return &pb.CheckAccessResponse{
+		Allowed: decision.Allowed,
+		Rule:    decision.Rule,
+		Reason:  decision.Reason,
+	}, nil
+}
+
+// accessResource describes the resource of an access check by a subject acting within orgId, or globally when it
+// is empty. Users are loaded by id, nil is returned if they do not exist or are outside the organisation; any
+// other type of resource is described by its attributes
+func accessResource(ctx context.Context, orgId, resourceType, resourceId string, attributes map[string]string) (map[string]any, error) {
+	if resourceType == resourceTypeUser {
+		user, err := mongodb.GetUserById(ctx, resourceId)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if user == nil || !visibleInOrganization(orgId, user) {
+			return nil, nil
+		}
+		return organizationUserResource(orgId, user), nil
+	}
+
+	resource := make(map[string]any, len(attributes)+2)
+	for key, value := range attributes {
+		resource[key] = value
+	}
+	resource["type"] = resourceType
+	resource["id"] = resourceId
+	return resource, nil
+}
+
+// contextAttributes exposes the context of an access check to the rules as request
+func contextAttributes(values map[string]string) map[string]any {
+	attributes := make(map[string]any, len(values))
+	for key, value := range values {
+		attributes[key] = value
+	}
+	return attributes
+}
+
+// userResource describes a user as the target of an action by the caller for the access rules
+func userResource(ctx context.Context, user *models.User) map[string]any {
+	return organizationUserResource(utils.CallerOrganization(ctx), user)
+}
+
+// organizationUserResource describes a user as the target of an action within orgId. roles are always the user's
+// global roles; within an organisation, org_roles are their roles there
+func organizationUserResource(orgId string, user *models.User) map[string]any {
+	resource := map[string]any{
+		"type":     resourceTypeUser,
+		"id":       user.Id,
+		"username": user.Username,
+		"email":    user.Email,
+		"roles":    append([]string{}, user.Roles...),
+		"status":   user.AccountStatus(),
+		"org_ids":  user.OrganizationIds(),
+	}
+	if orgId != "" {
+		resource["org_roles"] = append([]string{}, organizationRoles(orgId, user)...)
+	}
+	if user.OwnerOrgId != "" {
+		resource["owner_org_id"] = user.OwnerOrgId
+	}
+	return resource
+}
+
+// authorizeUserAccess checks the access rules for the caller performing an action on a user
+func authorizeUserAccess(ctx context.Context, action string, user *models.User, req proto.Message) error {
+	decision, err := utils.AuthorizeAccess(ctx, action, userResource(ctx, user), req)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !decision.Allowed {
+		return status.Error(codes.PermissionDenied, decision.Reason)
+	}
+	return nil
+}
+
+// targetUser loads the user an admin action applies to and checks the access rules for it. Users outside the
+// caller's organisation are not found
+func targetUser(ctx context.Context, action, userId string, req proto.Message) (*models.User, error) {
+	user, err := mongodb.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user == nil || !visibleToCaller(ctx, user) {
+		return nil, status.Error(codes.NotFound, "User not found")
+	}
+
+	err = authorizeUserAccess(ctx, action, user, req)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
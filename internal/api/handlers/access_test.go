@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	"testing"
+)
+
+func TestAuthorizeDescribesUsersFromSubjectOrganization(t *testing.T) {
+	utils.RoleRegistry.Replace(utils.DefaultRoles)
+	t.Cleanup(func() { utils.RoleRegistry.Replace(nil) })
+	err := utils.LoadAccessPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Created through an acme invitation and an admin there, but a plain user globally
+	orgAdmin := &models.User{
+		Id:          "64b7f0c2a1b2c3d4e5f60718",
+		Username:    "bob",
+		Roles:       []string{utils.RoleUser},
+		Memberships: []models.Membership{{OrgId: "acme", Roles: []string{utils.RoleAdmin}}},
+		OwnerOrgId:  "acme",
+	}
+	outsider := &models.User{Id: "64b7f0c2a1b2c3d4e5f60719", Username: "mallory", Roles: []string{utils.RoleUser}}
+
+	// The subject's token is scoped to acme, the calling service's token is not scoped at all
+	subject := utils.AccessSubject("64b7f0c2a1b2c3d4e5f6071a", "carol", "acme", []string{utils.RoleAdmin})
+	subjectOrgId, _ := subject["org_id"].(string)
+
+	if visibleInOrganization(subjectOrgId, outsider) {
+		t.Fatal("a user outside the subject's organisation is visible")
+	}
+	if !visibleInOrganization(subjectOrgId, orgAdmin) {
+		t.Fatal("a member of the subject's organisation is not visible")
+	}
+
+	resource := organizationUserResource(subjectOrgId, orgAdmin)
+	decision := utils.AccessPolicies.Evaluate(utils.AccessInput{Subject: subject, Action: utils.PermUsersSuspend, Resource: resource})
+	if decision.Allowed || decision.Rule != "protect-admins" {
+		t.Fatalf("org admin suspending another org admin: allowed %v by %q, want denied by protect-admins", decision.Allowed, decision.Rule)
+	}
+
+	// Described from the caller's unscoped token the target's admin role in acme would go unnoticed
+	if _, ok := organizationUserResource("", orgAdmin)["org_roles"]; ok {
+		t.Fatal("org_roles set without an organisation")
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"slices"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// SuspendUser lets admins block a user from logging in. Every token the user already holds is revoked
+func (s *Server) SuspendUser(ctx context.Context, req *pb.SuspendUserRequest) (*pb.SuspendUserResponse, error) {
+	user, err := manageableUser(ctx, utils.PermUsersSuspend, req.GetId(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetReason() != "" {
+		utils.AddAuditDetail(ctx, "reason", req.GetReason())
+	}
+
+	suspended, err := mongodb.SuspendUser(ctx, user.Id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !suspended {
+		return nil, status.Error(codes.FailedPrecondition, "User is already suspended or deleted")
+	}
+
+	if slices.Contains(user.Roles, utils.RoleSuperAdmin) {
+		err = undoIfLastSuperAdmin(ctx, func() error {
+			return mongodb.RestoreAccountStatus(ctx, user.Id, user.AccountStatus())
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &pb.SuspendUserResponse{
+		Status: true,
+	}, nil
+}
+
+// ReactivateUser lets admins lift a suspension. Tokens revoked by the suspension stay revoked
+func (s *Server) ReactivateUser(ctx context.Context, req *pb.ReactivateUserRequest) (*pb.ReactivateUserResponse, error) {
+	user, err := targetUser(ctx, utils.PermUsersSuspend, req.GetId(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	reactivated, err := mongodb.ReactivateUser(ctx, user.Id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !reactivated {
+		return nil, status.Error(codes.FailedPrecondition, "User is not suspended or pending verification")
+	}
+
+	return &pb.ReactivateUserResponse{
+		Status: true,
+	}, nil
+}
+
+// manageableUser loads the target of an admin action such as a suspension or deletion. Admins cannot act on
+// themselves, and the access rules decide which other users they may act on (see access_policy.yaml)
+func manageableUser(ctx context.Context, action, userId string, req proto.Message) (*models.User, error) {
+	currentUserId, _ := ctx.Value(utils.ContextKey("userId")).(string)
+	if userId == currentUserId {
+		return nil, status.Error(codes.FailedPrecondition, "You cannot do this to your own account")
+	}
+
+	return targetUser(ctx, action, userId, req)
+}
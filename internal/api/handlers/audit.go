@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// QueryAuditLog lets admins search the audit log
+func (s *Server) QueryAuditLog(ctx context.Context, req *pb.QueryAuditLogRequest) (*pb.QueryAuditLogResponse, error) {
+	pageSize, err := pageParams(req.GetPageSize(), req.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	filter := models.AuditFilter{
+		EventType: req.GetEventType(),
+		ActorId:   req.GetActorId(),
+		TargetId:  req.GetTargetId(),
+		Outcome:   req.GetOutcome(),
+		OrgId:     utils.CallerOrganization(ctx),
+	}
+	if req.GetSince() > 0 {
+		filter.Since = time.Unix(req.GetSince(), 0)
+	}
+	if req.GetUntil() > 0 {
+		filter.Until = time.Unix(req.GetUntil(), 0)
+	}
+
+	events, nextPageToken, err := mongodb.QueryAuditEvents(ctx, filter, pageSize, req.GetPageToken())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &pb.QueryAuditLogResponse{
+		NextPageToken: nextPageToken,
+	}
+	for _, event := range events {
+		response.Events = append(response.Events, &pb.AuditEvent{
+			Id:            event.Id,
+			EventType:     event.EventType,
+			ActorId:       event.ActorId,
+			ActorUsername: event.ActorUsername,
+			TargetId:      event.TargetId,
+			Ip:            event.IP,
+			UserAgent:     event.UserAgent,
+			Outcome:       event.Outcome,
+			Code:          event.Code,
+			Details:       event.Details,
+			Timestamp:     event.Timestamp.Unix(),
+		})
+	}
+
+	return response, nil
+}
+
+// VerifyAuditChain lets admins check that no audit event was edited or removed
+func (s *Server) VerifyAuditChain(ctx context.Context, req *pb.EmptyRequest) (*pb.VerifyAuditChainResponse, error) {
+	report, err := mongodb.VerifyAuditChain(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.VerifyAuditChainResponse{
+		Valid:     report.Reason == "",
+		Checked:   report.Checked,
+		HeadSeq:   report.HeadSeq,
+		HeadHash:  report.HeadHash,
+		BrokenSeq: report.BrokenSeq,
+		BrokenId:  report.BrokenId,
+		Reason:    report.Reason,
+	}, nil
+}
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"context"
+	"goAuth/internal/api/interceptors"
+	"goAuth/internal/models"
 	"goAuth/internal/repositories/mongodb"
 	"goAuth/pkg/utils"
 	pb "goAuth/proto/gen"
+	"slices"
 	"strings"
 	"time"
 
@@ -14,34 +17,160 @@ import (
 )
 
 func (s *Server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
-	user, err := mongodb.GetUserByUsername(ctx, req.GetUsername())
+	user, err := s.Accounts.GetUserByUsername(ctx, req.GetUsername())
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	err = utils.VerifyPassword(req.GetPassword(), user.Password)
+	// Unknown usernames get the same throttling, hashing work and error as a wrong password,
+	// so neither the response nor its timing reveals which usernames exist. Deleted users count as unknown
+	if user == nil || user.AccountStatus() == models.UserStatusDeleted {
+		return nil, s.failUnknownUserLogin(ctx, req.GetUsername(), req.GetPassword())
+	}
+	utils.SetAuditActor(ctx, user.Id, user.Username)
+
+	// Refuse attempts while the account is backing off or locked, before doing any password work
+	err = loginThrottleError(user.FailedLoginAttempts, user.LoginRetryAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Password == "" {
+		// Google-only accounts have no password but must cost as much as any other attempt
+		utils.VerifyDummyPassword(req.GetPassword())
+		err = errIncorrectCredentials
+	} else {
+		err = utils.VerifyPassword(req.GetPassword(), user.Password)
+	}
+	if err != nil {
+		failedUser, err := s.Accounts.RecordFailedLogin(ctx, user.Id)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if utils.IsLockedOut(failedUser.FailedLoginAttempts) {
+			return nil, loginThrottleError(failedUser.FailedLoginAttempts, failedUser.LoginRetryAt)
+		}
+		return nil, errIncorrectCredentials
+	}
+
+	// Checked only once the password is verified, so the status is not revealed to anyone else
+	err = interceptors.AccountStatusError(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Users imported from legacy systems are moved to the current Argon2id format on first login
+	if utils.NeedsRehash(user.Password) {
+		upgradeLegacyPassword(ctx, user.Id, req.GetPassword())
+	}
+
+	// Without TOTP, an unfamiliar device or network can be asked to confirm an emailed code
+	if !user.TotpEnabled && user.Email != "" && utils.NewDeviceMfaRequired() && unfamiliarLogin(user, loginDevice(ctx)) != "" {
+		return newDeviceChallenge(ctx, user)
+	}
+
+	return loginResponse(ctx, user)
+}
+
+// errIncorrectCredentials is the only error Login returns for a wrong username or password
+var errIncorrectCredentials = status.Error(codes.Unauthenticated, "Incorrect username or password")
+
+// failUnknownUserLogin mirrors a failed attempt against a real account for a username that does not exist:
+// a throttled attempt does no password work, any other is counted in the store and costs a password hash
+func (s *Server) failUnknownUserLogin(ctx context.Context, username, password string) error {
+	failedAttempts, retryAt, counted, err := s.UnknownUserLockouts.RecordFailure(ctx, username)
 	if err != nil {
-		return nil, status.Error(codes.Unauthenticated, "Incorrect username or password")
+		return status.Error(codes.Internal, err.Error())
 	}
+	if !counted {
+		err = loginThrottleError(failedAttempts, retryAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	utils.VerifyDummyPassword(password)
+
+	if utils.IsLockedOut(failedAttempts) {
+		return loginThrottleError(failedAttempts, retryAt)
+	}
+	return errIncorrectCredentials
+}
 
-	tokenString, err := utils.SignToken(user.Id, user.Username, user.Role)
+// loginResponse completes a login for a user whose credentials were verified.
+// Users with a second factor enabled receive an MFA challenge token instead of an access token
+func loginResponse(ctx context.Context, user *models.User) (*pb.LoginResponse, error) {
+	err := interceptors.AccountStatusError(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TotpEnabled {
+		mfaToken, err := utils.SignMfaToken(user.Id, user.FailedLoginAttempts)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "Could not create token")
+		}
+
+		return &pb.LoginResponse{
+			Status:      false,
+			MfaRequired: true,
+			MfaToken:    mfaToken,
+		}, nil
+	}
+
+	return signedLoginResponse(ctx, user)
+}
+
+// signedLoginResponse issues the access token once every required factor has been checked. Only then is the
+// failed login counter reset, so wrong MFA codes count towards the lockout like wrong passwords
+func signedLoginResponse(ctx context.Context, user *models.User) (*pb.LoginResponse, error) {
+	err := interceptors.AccountStatusError(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.FailedLoginAttempts > 0 {
+		err = mongodb.ResetFailedLogins(ctx, user.Id)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	tokenString, err := utils.SignToken(user.Id, user.Username, user.Roles)
 	if err != nil {
 		return nil, status.Error(codes.Unauthenticated, "Could not create token")
 	}
 
+	recordLoginDevice(ctx, user)
+
 	return &pb.LoginResponse{
 		Status: true,
 		Token:  tokenString,
 	}, nil
 }
 
+// upgradeLegacyPassword rehashes the password with Argon2id. Failures are only logged since the login itself succeeded
+func upgradeLegacyPassword(ctx context.Context, userId, password string) {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		utils.ErrorHandler(err, "Error rehashing legacy password")
+		return
+	}
+
+	err = mongodb.UpdateUserPassword(ctx, userId, hashedPassword)
+	if err != nil {
+		utils.ErrorHandler(err, "Error upgrading legacy password hash")
+	}
+}
+
 func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.LoginResponse, error) {
 	user, err := mongodb.AddUserToDB(ctx, req)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	utils.SetAuditActor(ctx, user.Id, user.Username)
 
-	tokenString, err := utils.SignToken(user.Id, user.Username, user.Role)
+	tokenString, err := utils.SignToken(user.Id, user.Username, user.Roles)
 	if err != nil {
 		return nil, status.Error(codes.Unauthenticated, "Could not create token")
 	}
@@ -53,24 +182,100 @@ func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.Log
 }
 
 func (s *Server) ChangeRole(ctx context.Context, req *pb.ChangeRoleRequest) (*pb.ChangeRoleResponse, error) {
-	// Allow only super admin to be able to change user roles
-	err := utils.AuthorizeUser(ctx, "super_admin")
+	userId := req.GetId()
+	updatedRoles := req.GetRoles()
+	if len(updatedRoles) == 0 && req.GetRole() != "" {
+		updatedRoles = []string{req.GetRole()}
+	}
+	slices.Sort(updatedRoles)
+	updatedRoles = slices.Compact(updatedRoles)
+
+	utils.AddAuditDetail(ctx, "new_roles", strings.Join(updatedRoles, ","))
+	if len(updatedRoles) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "At least one role is required")
+	}
+	// Holding users.role.write is not enough to hand out roles with permissions the caller does not have
+	updatedRoles, err := memberRoles(ctx, updatedRoles)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, err
 	}
 
-	userId := req.GetId()
-	updatedRole := req.GetRole()
-	err = mongodb.ModifyUserRoleInDB(ctx, userId, updatedRole)
+	user, err := targetUser(ctx, utils.PermUsersRoleWrite, userId, req)
+	if err != nil {
+		return nil, err
+	}
+	if orgId := utils.CallerOrganization(ctx); orgId != "" {
+		return changeMemberRoles(ctx, orgId, user, updatedRoles)
+	}
+
+	if slices.Contains(user.Roles, utils.RoleSuperAdmin) && !slices.Contains(updatedRoles, utils.RoleSuperAdmin) {
+		err = requireAnotherSuperAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	previousUser, err := mongodb.ModifyUserRoleInDB(ctx, userId, updatedRoles)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	if previousUser == nil {
+		return nil, status.Error(codes.NotFound, "User not found")
+	}
+
+	if slices.Contains(previousUser.Roles, utils.RoleSuperAdmin) && !slices.Contains(updatedRoles, utils.RoleSuperAdmin) {
+		err = undoIfLastSuperAdmin(ctx, func() error {
+			_, err := mongodb.ModifyUserRoleInDB(ctx, userId, previousUser.Roles)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	utils.AddAuditDetail(ctx, "old_roles", strings.Join(previousUser.Roles, ","))
 
 	return &pb.ChangeRoleResponse{
 		Status: true,
 	}, nil
 }
 
+// requireAnotherSuperAdmin refuses changes that would leave nobody able to manage roles,
+// such as the last active super admin demoting or deleting themselves
+func requireAnotherSuperAdmin(ctx context.Context) error {
+	superAdmins, err := mongodb.CountActiveUsersWithRole(ctx, utils.RoleSuperAdmin)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if superAdmins <= 1 {
+		return status.Error(codes.FailedPrecondition, "Cannot remove the last super_admin")
+	}
+	return nil
+}
+
+// undoIfLastSuperAdmin re-counts super admins once a change that removes one has been written and undoes it if
+// none is left. requireAnotherSuperAdmin alone lets two super admins demote or delete each other at the same time
+func undoIfLastSuperAdmin(ctx context.Context, undo func() error) error {
+	superAdmins, err := mongodb.CountActiveUsersWithRole(ctx, utils.RoleSuperAdmin)
+	return undoIfNoneLeft(superAdmins, err, "Cannot remove the last super_admin", undo)
+}
+
+// undoIfNoneLeft undoes a change when the count taken after it is zero or could not be taken
+func undoIfNoneLeft(remaining int64, countErr error, message string, undo func() error) error {
+	if countErr == nil && remaining > 0 {
+		return nil
+	}
+
+	err := undo()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if countErr != nil {
+		return status.Error(codes.Internal, countErr.Error())
+	}
+	return status.Error(codes.FailedPrecondition, message)
+}
+
 func (s *Server) Logout(ctx context.Context, req *pb.EmptyRequest) (*pb.LogoutResponse, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
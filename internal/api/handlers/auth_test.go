@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeAccounts is an AccountStore over a fixed set of users, keyed by username
+type fakeAccounts map[string]*models.User
+
+func (accounts fakeAccounts) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	user, ok := accounts[username]
+	if !ok {
+		return nil, nil
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// RecordFailedLogin counts failures by the same rules as mongodb.RecordFailedLogin
+func (accounts fakeAccounts) RecordFailedLogin(ctx context.Context, userId string) (*models.User, error) {
+	for _, user := range accounts {
+		if user.Id != userId {
+			continue
+		}
+
+		now := time.Now()
+		if now.After(utils.FailedLoginsExpireAt(user.LastFailedLoginAt)) {
+			user.FailedLoginAttempts = 0
+		}
+		user.FailedLoginAttempts++
+		user.LastFailedLoginAt = now
+		user.LoginRetryAt = utils.LoginRetryAt(user.FailedLoginAttempts, now)
+
+		copied := *user
+		return &copied, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func setLockoutEnv(t *testing.T) {
+	t.Helper()
+
+	// No back-off below the threshold, so every attempt up to the lockout is answered
+	t.Setenv("LOGIN_LOCKOUT_THRESHOLD", "3")
+	t.Setenv("LOGIN_BACKOFF_BASE", "0s")
+	t.Setenv("LOGIN_LOCKOUT_DURATION", "1h")
+	t.Setenv("LOGIN_LOCKOUT_MAX", "24h")
+}
+
+// testLoginServer returns a server with one account, alice, whose password is "correct horse"
+func testLoginServer(t *testing.T) *Server {
+	t.Helper()
+
+	password, err := utils.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{
+		Accounts: fakeAccounts{
+			"alice": {Id: "64b7f0c2a1b2c3d4e5f60718", Username: "alice", Password: password},
+		},
+		UnknownUserLockouts: utils.NewMemoryUnknownUserLockouts(100),
+	}
+}
+
+func login(s *Server, username string) error {
+	_, err := s.Login(context.Background(), &pb.LoginRequest{Username: username, Password: "wrong password"})
+	return err
+}
+
+func assertSameLoginError(t *testing.T, attempt int, known, unknown error) {
+	t.Helper()
+
+	knownStatus, unknownStatus := status.Convert(known), status.Convert(unknown)
+	if knownStatus.Code() != unknownStatus.Code() || knownStatus.Message() != unknownStatus.Message() {
+		t.Fatalf("attempt %d: known user got %v, unknown user got %v", attempt, known, unknown)
+	}
+	if len(knownStatus.Details()) != len(unknownStatus.Details()) {
+		t.Fatalf("attempt %d: known user got %d details, unknown user got %d", attempt, len(knownStatus.Details()), len(unknownStatus.Details()))
+	}
+}
+
+func TestUnknownUsernameLoginLooksLikeWrongPassword(t *testing.T) {
+	setLockoutEnv(t)
+	s := testLoginServer(t)
+
+	for attempt := 1; attempt <= 5; attempt++ {
+		assertSameLoginError(t, attempt, login(s, "alice"), login(s, "nobody"))
+	}
+}
+
+func TestFailedLoginsExpireForKnownAndUnknownUsernames(t *testing.T) {
+	setLockoutEnv(t)
+	t.Setenv("LOGIN_LOCKOUT_MAX", "50ms")
+	s := testLoginServer(t)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		assertSameLoginError(t, attempt, login(s, "alice"), login(s, "nobody"))
+	}
+
+	// Once forgotten, the next failure counts as the first for both, rather than extending the lockout of one
+	time.Sleep(100 * time.Millisecond)
+	known, unknown := login(s, "alice"), login(s, "nobody")
+	assertSameLoginError(t, 4, known, unknown)
+	if known != errIncorrectCredentials {
+		t.Fatalf("first failure after the lockout expired got %v", known)
+	}
+}
+
+func TestUnknownUsernameLockoutIsPerUsername(t *testing.T) {
+	setLockoutEnv(t)
+	s := testLoginServer(t)
+
+	for attempt := 0; attempt < 3; attempt++ {
+		_ = login(s, "nobody")
+	}
+
+	err := login(s, "somebody")
+	if err != errIncorrectCredentials {
+		t.Fatalf("first attempt for another username got %v", err)
+	}
+}
+
+func TestChangeRoleOnlyGrantsHeldPermissions(t *testing.T) {
+	roleManager := utils.RoleDefinition{Name: "role_manager", Permissions: []string{utils.PermUsersRoleWrite}}
+	utils.RoleRegistry.Replace(append(slices.Clone(utils.DefaultRoles), roleManager))
+	t.Cleanup(func() { utils.RoleRegistry.Replace(nil) })
+
+	ctx := context.WithValue(context.Background(), utils.ContextKey("roles"), []string{roleManager.Name})
+	_, err := (&Server{}).ChangeRole(ctx, &pb.ChangeRoleRequest{Id: "64b7f0c2a1b2c3d4e5f60718", Roles: []string{utils.RoleSuperAdmin}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("granting super_admin with only %s got %v", utils.PermUsersRoleWrite, err)
+	}
+}
+
+func TestInvitedAccountNeedsItsPassword(t *testing.T) {
+	setLockoutEnv(t)
+	s := testLoginServer(t)
+	accounts := s.Accounts.(fakeAccounts)
+	ctx := context.Background()
+
+	// The invitation token alone proves control of the email, not of the account registered with it
+	err := s.checkInvitedAccount(ctx, accounts["alice"], "")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("no sign in or password got %v", err)
+	}
+
+	err = s.checkInvitedAccount(ctx, accounts["alice"], "wrong password")
+	if err != errIncorrectPassword {
+		t.Fatalf("wrong password got %v", err)
+	}
+	if accounts["alice"].FailedLoginAttempts != 1 {
+		t.Fatalf("wrong password counted %d failed logins, want 1", accounts["alice"].FailedLoginAttempts)
+	}
+
+	err = s.checkInvitedAccount(ctx, accounts["alice"], "correct horse")
+	if err != nil {
+		t.Fatalf("correct password got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/api/interceptors"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Most decisions a single AuthorizeMany call may ask for
+const maxAuthorizeBatch = 100
+
+// Authorize answers "may this user do this to that resource?" for other services, using the roles and access
+// rules of goAuth so they do not have to reimplement them
+func (s *Server) Authorize(ctx context.Context, req *pb.AuthorizeRequest) (*pb.AuthorizeResponse, error) {
+	utils.AddAuditDetail(ctx, "action", req.GetAction())
+
+	response, err := authorizeDecision(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.AddAuditDetail(ctx, "allowed", strconv.FormatBool(response.Allowed))
+	return response, nil
+}
+
+// AuthorizeMany makes several Authorize decisions at once
+func (s *Server) AuthorizeMany(ctx context.Context, req *pb.AuthorizeManyRequest) (*pb.AuthorizeManyResponse, error) {
+	requests := req.GetRequests()
+	if len(requests) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "At least one request is required")
+	}
+	if len(requests) > maxAuthorizeBatch {
+		return nil, status.Error(codes.InvalidArgument, "At most "+strconv.Itoa(maxAuthorizeBatch)+" requests are allowed")
+	}
+	utils.AddAuditDetail(ctx, "requests", strconv.Itoa(len(requests)))
+
+	response := &pb.AuthorizeManyResponse{
+		Results: make([]*pb.AuthorizeResponse, 0, len(requests)),
+	}
+	for i, request := range requests {
+		result, err := authorizeDecision(ctx, request)
+		if err != nil {
+			if status.Code(err) == codes.InvalidArgument {
+				return nil, status.Error(codes.InvalidArgument, "Request "+strconv.Itoa(i)+": "+status.Convert(err).Message())
+			}
+			return nil, err
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	return response, nil
+}
+
+// authorizeDecision evaluates one Authorize request. The subject is always checked first, so suspensions,
+// revocations and role changes apply at once; only the decision itself is cached, by subject, policy version and
+// request, for utils.AuthorizationCacheTTL but never beyond the expiry of the subject's token
+func authorizeDecision(ctx context.Context, req *pb.AuthorizeRequest) (*pb.AuthorizeResponse, error) {
+	if req.GetAction() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Action is required")
+	}
+	if (req.GetSubjectToken() == "") == (req.GetSubjectId() == "") {
+		return nil, status.Error(codes.InvalidArgument, "Set exactly one of subject_token or subject_id")
+	}
+
+	policyVersion := utils.PolicyVersion()
+
+	subject, subjectExpiresAt, reason, err := authorizationSubject(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		return deniedAuthorization(reason, policyVersion), nil
+	}
+
+	cacheKey := utils.AuthorizationCacheKey(utils.AccessSubjectKey(subject), policyVersion, []any{
+		req.GetAction(), req.GetResourceType(), req.GetResourceId(), req.GetResourceAttributes(), req.GetContext(),
+	})
+	if decision, ok := utils.AuthorizationCache.Get(cacheKey); ok {
+		response := authorizationResponse(decision, policyVersion)
+		response.Cached = true
+		return response, nil
+	}
+
+	// Users are described as seen from the subject's organisation, not from the calling service's token
+	subjectOrgId, _ := subject["org_id"].(string)
+	resource, err := accessResource(ctx, subjectOrgId, req.GetResourceType(), req.GetResourceId(), req.GetResourceAttributes())
+	if err != nil {
+		return nil, err
+	}
+	if resource == nil {
+		return deniedAuthorization("Resource not found", policyVersion), nil
+	}
+
+	decision := utils.AccessPolicies.Evaluate(utils.AccessInput{
+		Subject:  subject,
+		Action:   req.GetAction(),
+		Resource: resource,
+		Request:  contextAttributes(req.GetContext()),
+	})
+
+	if ttl := utils.AuthorizationCacheTTL(); ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		if !subjectExpiresAt.IsZero() && subjectExpiresAt.Before(expiresAt) {
+			expiresAt = subjectExpiresAt
+		}
+		utils.AuthorizationCache.Put(cacheKey, decision, expiresAt)
+	}
+
+	return authorizationResponse(decision, policyVersion), nil
+}
+
+// authorizationSubject identifies the subject of an Authorize request and when its token expires, if it was
+// identified by one. A non-empty reason means the subject cannot be authorized at all, e.g. it is suspended
+func authorizationSubject(ctx context.Context, req *pb.AuthorizeRequest) (map[string]any, time.Time, string, error) {
+	if req.GetSubjectToken() == "" {
+		user, err := mongodb.GetUserById(ctx, req.GetSubjectId())
+		if err != nil {
+			return nil, time.Time{}, "", status.Error(codes.Internal, err.Error())
+		}
+		if user == nil || user.AccountStatus() == models.UserStatusDeleted {
+			return nil, time.Time{}, "Subject not found", nil
+		}
+		if err := interceptors.AccountStatusError(user); err != nil {
+			return nil, time.Time{}, status.Convert(err).Message(), nil
+		}
+		return utils.AccessSubject(user.Id, user.Username, "", user.Roles), time.Time{}, "", nil
+	}
+
+	claims, err := interceptors.AuthenticateToken(ctx, req.GetSubjectToken())
+	if status.Code(err) == codes.Internal {
+		return nil, time.Time{}, "", err
+	}
+	if err != nil {
+		return nil, time.Time{}, status.Convert(err).Message(), nil
+	}
+
+	return utils.AccessSubject(claims.UserId, claims.Username, claims.OrgId, claims.Roles), time.Unix(claims.ExpiresAt, 0), "", nil
+}
+
+func authorizationResponse(decision utils.AccessDecision, policyVersion string) *pb.AuthorizeResponse {
+	return &pb.AuthorizeResponse{
+		Allowed:       decision.Allowed,
+		Reason:        decision.Reason,
+		Rule:          decision.Rule,
+		PolicyVersion: policyVersion,
+	}
+}
+
+func deniedAuthorization(reason, policyVersion string) *pb.AuthorizeResponse {
+	return &pb.AuthorizeResponse{
+		Reason:        reason,
+		PolicyVersion: policyVersion,
+	}
+}
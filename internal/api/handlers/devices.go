@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// loginDevice describes the device and network the current request comes from
+func loginDevice(ctx context.Context) models.KnownDevice {
+	now := time.Now()
+	return models.KnownDevice{
+		Fingerprint: utils.DeviceFingerprint(ctx),
+		IPPrefix:    utils.IPPrefix(utils.ClientIP(ctx)),
+		UserAgent:   utils.UserAgent(ctx),
+		FirstSeenAt: now,
+		LastSeenAt:  now,
+	}
+}
+
+// unfamiliarLogin explains why a login does not match the user's history, or returns an empty string if it does.
+// Users without any history yet (e.g. right after registering) are never flagged
+func unfamiliarLogin(user *models.User, device models.KnownDevice) string {
+	if len(user.KnownDevices) == 0 {
+		return ""
+	}
+
+	knownDevice, knownNetwork := false, false
+	for _, known := range user.KnownDevices {
+		knownDevice = knownDevice || known.Fingerprint == device.Fingerprint
+		knownNetwork = knownNetwork || known.IPPrefix == device.IPPrefix
+	}
+
+	switch {
+	case !knownDevice && !knownNetwork:
+		return "new device and network"
+	case !knownDevice:
+		return "new device"
+	case !knownNetwork:
+		return "new network"
+	}
+	return ""
+}
+
+// recordLoginDevice remembers the device of a completed login and emails the user if it was unfamiliar.
+// Failures are only logged since the login itself succeeded
+func recordLoginDevice(ctx context.Context, user *models.User) {
+	device := loginDevice(ctx)
+
+	reason := unfamiliarLogin(user, device)
+	if reason != "" {
+		utils.AddAuditDetail(ctx, "unfamiliar_login", reason)
+		if user.Email != "" {
+			body := fmt.Sprintf("Your account was just signed in to from a %s.\n\nTime: %s\nDevice: %s\nIP address: %s\n\n"+
+				"If this was you, no action is needed. Otherwise change your password immediately.",
+				reason, device.LastSeenAt.UTC().Format(time.RFC1123), device.UserAgent, utils.ClientIP(ctx))
+			utils.NotifyAsync(user.Email, "New sign-in to your account", body)
+		}
+	}
+
+	err := mongodb.RecordKnownDevice(ctx, user.Id, device)
+	if err != nil {
+		utils.ErrorHandler(err, "Error recording login device")
+	}
+}
+
+// newDeviceChallenge emails a one-time code to a user without TOTP who logs in from an unfamiliar device or
+// network, and answers with an MFA challenge that VerifyMfa completes with that code
+func newDeviceChallenge(ctx context.Context, user *models.User) (*pb.LoginResponse, error) {
+	code, err := utils.GenerateLoginCode()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	codeHash, err := utils.HashPassword(code)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error hashing login code")
+	}
+
+	stored, err := mongodb.SetLoginCode(ctx, user.Id, codeHash, time.Now().Add(utils.LoginCodeExpiresIn))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !stored {
+		// The password was already verified, so the limit can be reported
+		return nil, status.Error(codes.ResourceExhausted, "Too many codes requested, try again later")
+	}
+
+	mfaToken, err := utils.SignMfaToken(user.Id, user.FailedLoginAttempts)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Could not create token")
+	}
+
+	utils.AddAuditDetail(ctx, "step_up", "email_code")
+	utils.NotifyAsync(user.Email, "Confirm your sign-in",
+		"We noticed a sign-in from a device or network you have not used before. Your confirmation code is "+
+			code+". It expires in 10 minutes. If this was not you, change your password immediately.")
+
+	return &pb.LoginResponse{
+		Status:      false,
+		MfaRequired: true,
+		MfaToken:    mfaToken,
+	}, nil
+}
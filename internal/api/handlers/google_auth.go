@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"goAuth/internal/api/interceptors"
 	"goAuth/internal/repositories/mongodb"
 	"goAuth/pkg/utils"
 	pb "goAuth/proto/gen"
@@ -33,8 +34,14 @@ func (s *Server) GoogleLogin(ctx context.Context, req *pb.GoogleLoginRequest) (*
 			return nil, status.Error(codes.Internal, "Error checking for existing user by email")
 		}
 
-		// If user exists by email but not Google ID, update their Google ID
+		// If user exists by email but not Google ID, update their Google ID. Accounts that may not log in
+		// are refused first, so a suspended or deleted account is never linked
 		if existingUser != nil {
+			err = interceptors.AccountStatusError(existingUser)
+			if err != nil {
+				return nil, err
+			}
+
 			// Update existing user with Google ID and picture
 			err = mongodb.UpdateUserGoogleInfo(ctx, existingUser.Id, googleUser.Sub, googleUser.Picture)
 			if err != nil {
@@ -52,21 +59,31 @@ func (s *Server) GoogleLogin(ctx context.Context, req *pb.GoogleLoginRequest) (*
 				return nil, status.Error(codes.Internal, "Error creating new user")
 			}
 			user = mongodb.MapModelUserToPbUser(newUser)
+			existingUser = newUser
 		}
 	} else {
+		err = interceptors.AccountStatusError(existingUser)
+		if err != nil {
+			return nil, err
+		}
+
 		// User exists, use their data
 		user = mongodb.MapModelUserToPbUser(existingUser)
 	}
 
+	utils.SetAuditActor(ctx, user.Id, user.Username)
+
 	// Generate access token (JWT)
-	accessToken, err := utils.SignToken(user.Id, user.Username, user.Role)
+	accessToken, err := utils.SignToken(user.Id, user.Username, user.Roles)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "Could not create access token")
 	}
 
+	recordLoginDevice(ctx, existingUser)
+
 	// Generate refresh token (for now, using the same token generation)
 	// In a production environment, you'd want a separate refresh token mechanism
-	refreshToken, err := utils.SignToken(user.Id, user.Username, user.Role)
+	refreshToken, err := utils.SignToken(user.Id, user.Username, user.Roles)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "Could not create refresh token")
 	}
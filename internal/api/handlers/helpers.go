@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// currentUser loads the user making the request, as identified by the authentication interceptor
+func currentUser(ctx context.Context) (*models.User, error) {
+	userId, ok := ctx.Value(utils.ContextKey("userId")).(string)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized access")
+	}
+
+	user, err := mongodb.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user == nil {
+		return nil, status.Error(codes.NotFound, "User not found")
+	}
+
+	return user, nil
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// pageParams applies the default and maximum page size and validates the page token of a paginated request.
+// Page tokens are the ObjectID of the last item of the previous page
+func pageParams(requestedSize int32, pageToken string) (int, error) {
+	pageSize := int(requestedSize)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	if pageToken != "" && !primitive.IsValidObjectID(pageToken) {
+		return 0, status.Error(codes.InvalidArgument, "Invalid page token")
+	}
+
+	return pageSize, nil
+}
+
+// publicUser maps a user for admin facing responses, without the password hash
+func publicUser(user *models.User) *pb.User {
+	pbUser := mongodb.MapModelUserToPbUser(user)
+	pbUser.Password = ""
+	pbUser.Status = user.AccountStatus()
+
+	objId, err := primitive.ObjectIDFromHex(user.Id)
+	if err == nil {
+		pbUser.CreatedAt = objId.Timestamp().Unix()
+	}
+
+	return pbUser
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/api/interceptors"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errInvalidInvitation is the only error AcceptInvitation returns for unknown, revoked, expired or used invitations
+var errInvalidInvitation = status.Error(codes.Unauthenticated, "Invalid or expired invitation")
+
+// errIncorrectPassword is returned when the password given to attach an existing account is wrong
+var errIncorrectPassword = status.Error(codes.Unauthenticated, "Incorrect password")
+
+// InviteMember emails an invitation to join the caller's organisation with the given roles
+func (s *Server) InviteMember(ctx context.Context, req *pb.InviteMemberRequest) (*pb.InvitationResponse, error) {
+	orgId := utils.CallerOrganization(ctx)
+
+	email := utils.NormalizeEmail(req.GetEmail())
+	if !strings.Contains(email, "@") || strings.ContainsAny(email, "\r\n") {
+		return nil, status.Error(codes.InvalidArgument, "A valid email is required")
+	}
+
+	roles, err := memberRoles(ctx, req.GetRoles())
+	if err != nil {
+		return nil, err
+	}
+	utils.AddAuditDetail(ctx, "roles", strings.Join(roles, ","))
+
+	user, err := mongodb.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user != nil && user.Membership(orgId) != nil {
+		return nil, status.Error(codes.AlreadyExists, "User is already a member")
+	}
+
+	pending, err := mongodb.HasPendingInvitation(ctx, orgId, email)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if pending {
+		return nil, status.Error(codes.AlreadyExists, "This email already has a pending invitation, revoke it to send a new one")
+	}
+
+	invitedBy, _ := ctx.Value(utils.ContextKey("userId")).(string)
+	err = checkInvitationLimits(ctx, orgId, invitedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := mongodb.GetOrganization(ctx, orgId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if org == nil {
+		return nil, status.Error(codes.NotFound, "Organization not found")
+	}
+
+	now := time.Now()
+	invitation, err := mongodb.CreateInvitation(ctx, &models.Invitation{
+		OrgId:     orgId,
+		Email:     email,
+		Roles:     roles,
+		InvitedBy: invitedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(utils.InvitationExpiresIn()),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	utils.SetAuditTarget(ctx, invitation.Id)
+
+	token, err := utils.SignInvitationToken(invitation.Id, invitation.ExpiresAt)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Could not create invitation token")
+	}
+
+	body := "You have been invited to join " + org.Name + ". Use the invitation below to accept it, it expires on " +
+		invitation.ExpiresAt.UTC().Format(time.RFC1123) + ".\n\n" + utils.InvitationLink(token)
+	utils.NotifyAsync(email, "Invitation to join "+org.Name, body)
+
+	return &pb.InvitationResponse{
+		Invitation: invitationToPb(invitation),
+	}, nil
+}
+
+// checkInvitationLimits keeps an organisation, and each user across organisations, from sending more than
+// the configured number of invitations per INVITATION_LIMIT_WINDOW
+func checkInvitationLimits(ctx context.Context, orgId, invitedBy string) error {
+	since := time.Now().Add(-utils.InvitationLimitWindow())
+
+	if limit := utils.InvitationLimitPerOrg(); limit > 0 {
+		sent, err := mongodb.CountInvitationsSince(ctx, orgId, "", since)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if sent >= int64(limit) {
+			return status.Error(codes.ResourceExhausted, "The organization has sent too many invitations, try again later")
+		}
+	}
+
+	if limit := utils.InvitationLimitPerUser(); limit > 0 {
+		sent, err := mongodb.CountInvitationsSince(ctx, "", invitedBy, since)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if sent >= int64(limit) {
+			return status.Error(codes.ResourceExhausted, "You have sent too many invitations, try again later")
+		}
+	}
+
+	return nil
+}
+
+// ListInvitations lists the invitations of the caller's organisation, newest first
+func (s *Server) ListInvitations(ctx context.Context, req *pb.ListInvitationsRequest) (*pb.ListInvitationsResponse, error) {
+	switch req.GetStatus() {
+	case "", models.InvitationStatusPending, models.InvitationStatusAccepted, models.InvitationStatusRevoked, models.InvitationStatusExpired:
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Status must be pending, accepted, revoked or expired")
+	}
+
+	invitations, err := mongodb.ListInvitations(ctx, utils.CallerOrganization(ctx), req.GetStatus())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &pb.ListInvitationsResponse{}
+	for i := range invitations {
+		response.Invitations = append(response.Invitations, invitationToPb(&invitations[i]))
+	}
+	return response, nil
+}
+
+// RevokeInvitation cancels a pending invitation of the caller's organisation, so its token can no longer be used
+func (s *Server) RevokeInvitation(ctx context.Context, req *pb.RevokeInvitationRequest) (*pb.RevokeInvitationResponse, error) {
+	utils.SetAuditTarget(ctx, req.GetId())
+
+	revoked, err := mongodb.RevokeInvitation(ctx, utils.CallerOrganization(ctx), req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !revoked {
+		return nil, status.Error(codes.NotFound, "Invitation not found")
+	}
+
+	return &pb.RevokeInvitationResponse{
+		Status: true,
+	}, nil
+}
+
+// AcceptInvitation adds the invited email's account to the organisation. If the email is not registered the account
+// is created first and logged in like LoginWithCode does, since the emailed token proves control of the email. An
+// existing account is only added for a caller signed in as it or giving its password, and is not logged in.
+// The invitation is claimed before anything else is written, so two concurrent requests cannot both use it
+func (s *Server) AcceptInvitation(ctx context.Context, req *pb.AcceptInvitationRequest) (*pb.LoginResponse, error) {
+	invitationId, err := utils.ParseInvitationToken(req.GetToken())
+	if err != nil {
+		return nil, errInvalidInvitation
+	}
+
+	invitation, err := mongodb.GetInvitation(ctx, invitationId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if invitation == nil || invitation.CurrentStatus() != models.InvitationStatusPending {
+		return nil, errInvalidInvitation
+	}
+	utils.SetAuditTarget(ctx, invitation.Id)
+	utils.AddAuditDetail(ctx, "org_id", invitation.OrgId)
+
+	user, err := mongodb.GetUserByEmail(ctx, invitation.Email)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	existing := user != nil
+	if existing {
+		utils.SetAuditActor(ctx, user.Id, user.Username)
+		err = interceptors.AccountStatusError(user)
+		if err == nil {
+			err = s.checkInvitedAccount(ctx, user, req.GetPassword())
+		}
+	} else {
+		err = checkNewAccount(ctx, req.GetUsername(), req.GetPassword())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := mongodb.ClaimInvitation(ctx, invitation.Id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !claimed {
+		return nil, errInvalidInvitation
+	}
+
+	user, err = joinInvitedOrganization(ctx, invitation, user, req.GetUsername(), req.GetPassword())
+	if err != nil {
+		releaseErr := mongodb.ReleaseInvitation(ctx, invitation.Id)
+		if releaseErr != nil {
+			utils.ErrorHandler(releaseErr, "Error releasing invitation "+invitation.Id)
+		}
+		return nil, err
+	}
+
+	if existing {
+		return &pb.LoginResponse{Status: true}, nil
+	}
+	return loginResponse(ctx, user)
+}
+
+// checkInvitedAccount makes sure whoever accepts an invitation for an existing account controls that account, as
+// the invitation token only proves control of the email. The caller either sends an access token for the account
+// or its password, which is throttled and counted like a Login attempt
+func (s *Server) checkInvitedAccount(ctx context.Context, user *models.User, password string) error {
+	if token := interceptors.BearerToken(ctx); token != "" {
+		claims, err := interceptors.AuthenticateToken(ctx, token)
+		if err != nil {
+			return err
+		}
+		if claims.UserId != user.Id {
+			return status.Error(codes.PermissionDenied, "The invitation was sent to another account")
+		}
+		return nil
+	}
+
+	if password == "" {
+		return status.Error(codes.Unauthenticated, "Sign in or give the account's password to accept the invitation")
+	}
+
+	err := loginThrottleError(user.FailedLoginAttempts, user.LoginRetryAt)
+	if err != nil {
+		return err
+	}
+
+	if user.Password == "" {
+		// Google-only accounts have no password and can only accept while signed in
+		utils.VerifyDummyPassword(password)
+		err = errIncorrectPassword
+	} else {
+		err = utils.VerifyPassword(password, user.Password)
+	}
+	if err != nil {
+		failedUser, err := s.Accounts.RecordFailedLogin(ctx, user.Id)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if utils.IsLockedOut(failedUser.FailedLoginAttempts) {
+			return loginThrottleError(failedUser.FailedLoginAttempts, failedUser.LoginRetryAt)
+		}
+		return errIncorrectPassword
+	}
+	return nil
+}
+
+// joinInvitedOrganization does the writes of accepting a claimed invitation: it creates the account if there is
+// none, records who accepted and adds the membership. Someone who joined in the meantime keeps their current roles
+func joinInvitedOrganization(ctx context.Context, invitation *models.Invitation, user *models.User, username, password string) (*models.User, error) {
+	if user == nil {
+		created, err := mongodb.AddInvitedUserToDB(ctx, &pb.RegisterRequest{
+			Username: username,
+			Password: password,
+			Email:    invitation.Email,
+		}, invitation.OrgId)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		user, err = mongodb.GetUserById(ctx, created.Id)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if user == nil {
+			return nil, status.Error(codes.Internal, "Error reading created account")
+		}
+		utils.SetAuditActor(ctx, user.Id, user.Username)
+		utils.AddAuditDetail(ctx, "created_account", "true")
+	}
+
+	err := mongodb.SetInvitationAcceptedBy(ctx, invitation.Id, user.Id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	_, err = mongodb.AddMembership(ctx, user.Id, models.Membership{
+		OrgId:    invitation.OrgId,
+		Roles:    invitation.Roles,
+		JoinedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return user, nil
+}
+
+// checkNewAccount validates the account details given with an invitation for an email that has no account yet
+func checkNewAccount(ctx context.Context, username, password string) error {
+	if username == "" || password == "" {
+		return status.Error(codes.InvalidArgument, "Username and password are required to create the account")
+	}
+
+	existing, err := mongodb.GetUserByUsername(ctx, username)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if existing != nil {
+		return status.Error(codes.AlreadyExists, "Username is already taken")
+	}
+	return nil
+}
+
+func invitationToPb(invitation *models.Invitation) *pb.Invitation {
+	return &pb.Invitation{
+		Id:        invitation.Id,
+		Email:     invitation.Email,
+		Roles:     invitation.Roles,
+		Status:    invitation.CurrentStatus(),
+		InvitedBy: invitation.InvitedBy,
+		CreatedAt: invitation.CreatedAt.Unix(),
+		ExpiresAt: invitation.ExpiresAt.Unix(),
+	}
+}
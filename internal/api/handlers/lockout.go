@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetLockStatus lets admins see whether a user is being throttled or locked out
+func (s *Server) GetLockStatus(ctx context.Context, req *pb.LockStatusRequest) (*pb.LockStatusResponse, error) {
+	user, err := targetUser(ctx, utils.PermUsersRead, req.GetId(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &pb.LockStatusResponse{
+		FailedAttempts: int32(user.FailedLoginAttempts),
+		Locked:         utils.IsLockedOut(user.FailedLoginAttempts) && time.Now().Before(user.LoginRetryAt),
+	}
+	if time.Now().Before(user.LoginRetryAt) {
+		response.RetryAt = user.LoginRetryAt.Unix()
+	}
+	if !user.LastFailedLoginAt.IsZero() {
+		response.LastFailedAt = user.LastFailedLoginAt.Unix()
+	}
+
+	return response, nil
+}
+
+// UnlockUser lets admins lift a lockout before it expires
+func (s *Server) UnlockUser(ctx context.Context, req *pb.UnlockUserRequest) (*pb.UnlockUserResponse, error) {
+	user, err := targetUser(ctx, utils.PermUsersUnlock, req.GetId(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mongodb.ResetFailedLogins(ctx, user.Id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.UnlockUserResponse{
+		Status: true,
+	}, nil
+}
+
+// loginThrottleError returns ResourceExhausted while backing off between failed attempts and
+// PermissionDenied once the account is locked, both carrying a RetryInfo detail. It returns nil if a login may be attempted
+func loginThrottleError(failedAttempts int, retryAt time.Time) error {
+	retryAfter := time.Until(retryAt)
+	if retryAfter <= 0 {
+		return nil
+	}
+
+	if utils.IsLockedOut(failedAttempts) {
+		return utils.RetryAfterError(codes.PermissionDenied, "Account is temporarily locked due to too many failed login attempts", retryAfter)
+	}
+	return utils.RetryAfterError(codes.ResourceExhausted, "Too many failed login attempts, try again later", retryAfter)
+}
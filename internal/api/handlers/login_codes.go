@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RequestLoginCode emails a one-time code or magic link. The response is identical whether or not
+// the email is registered, so it cannot be used to discover accounts
+func (s *Server) RequestLoginCode(ctx context.Context, req *pb.RequestLoginCodeRequest) (*pb.RequestLoginCodeResponse, error) {
+	delivery := req.GetDelivery()
+	if delivery == "" {
+		delivery = "code"
+	}
+	if delivery != "code" && delivery != "link" {
+		return nil, status.Error(codes.InvalidArgument, "Delivery must be either code or link")
+	}
+
+	if req.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Email is required")
+	}
+
+	// Checked before the lookup so the answer does not depend on whether the email is registered
+	if delivery == "link" && utils.MagicLinksConfigured() != nil {
+		return nil, status.Error(codes.FailedPrecondition, "Magic links are not configured")
+	}
+
+	// The lookup, hashing and storing happen off the request path, so known and unknown emails take as long
+	go sendLoginCode(req.GetEmail(), delivery)
+
+	return &pb.RequestLoginCodeResponse{Status: true}, nil
+}
+
+// sendLoginCode stores a new login code and emails it, as a code or magic link, if the email is registered
+func sendLoginCode(email, delivery string) {
+	ctx := context.Background()
+
+	user, err := mongodb.GetUserByEmail(ctx, email)
+	if err != nil {
+		utils.ErrorHandler(err, "Error looking up login code")
+		return
+	}
+	// Deleted users are treated as unknown, as in Login
+	if user == nil || user.AccountStatus() == models.UserStatusDeleted {
+		return
+	}
+
+	code, err := utils.GenerateLoginCode()
+	if err != nil {
+		utils.ErrorHandler(err, "Error generating login code")
+		return
+	}
+
+	codeHash, err := utils.HashPassword(code)
+	if err != nil {
+		utils.ErrorHandler(err, "Error hashing login code")
+		return
+	}
+
+	var subject, body string
+	if delivery == "link" {
+		link, err := utils.MagicLink(user.Id, code)
+		if err != nil {
+			utils.ErrorHandler(err, "Error creating magic link")
+			return
+		}
+		subject = "Your sign-in link"
+		body = "Click the link below to sign in. It expires in 10 minutes and can only be used once.\n\n" + link
+	} else {
+		subject = "Your sign-in code"
+		body = "Your sign-in code is " + code + ". It expires in 10 minutes and can only be used once."
+	}
+
+	// Past the per-account limit nothing is sent, which the caller cannot tell apart from an unknown email
+	stored, err := mongodb.SetLoginCode(ctx, user.Id, codeHash, time.Now().Add(utils.LoginCodeExpiresIn))
+	if err != nil {
+		utils.ErrorHandler(err, "Error storing login code")
+		return
+	}
+	if !stored {
+		return
+	}
+
+	utils.NotifyAsync(user.Email, subject, body)
+}
+
+// LoginWithCode verifies an emailed code (or magic link token) and completes the login like Login does
+func (s *Server) LoginWithCode(ctx context.Context, req *pb.LoginWithCodeRequest) (*pb.LoginResponse, error) {
+	var user *models.User
+	var code string
+	var err error
+
+	if req.GetToken() != "" {
+		var userId string
+		userId, code, err = utils.ParseMagicLinkToken(req.GetToken())
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "Invalid or expired code")
+		}
+		user, err = mongodb.GetUserById(ctx, userId)
+	} else {
+		code = req.GetCode()
+		user, err = mongodb.GetUserByEmail(ctx, req.GetEmail())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if user == nil || user.AccountStatus() == models.UserStatusDeleted {
+		return nil, status.Error(codes.Unauthenticated, "Invalid or expired code")
+	}
+	utils.SetAuditActor(ctx, user.Id, user.Username)
+
+	// Wrong codes are throttled like wrong passwords and count towards the same lockout
+	err = loginThrottleError(user.FailedLoginAttempts, user.LoginRetryAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifyLoginCode(ctx, user, code)
+	if err != nil {
+		if status.Code(err) != codes.Unauthenticated {
+			return nil, err
+		}
+		return nil, recordFailedCode(ctx, user.Id, err)
+	}
+
+	return loginResponse(ctx, user)
+}
+
+// verifyLoginCode checks an emailed code against the user's pending login code and consumes it
+func verifyLoginCode(ctx context.Context, user *models.User, code string) error {
+	if user.LoginCodeHash == "" || time.Now().After(user.LoginCodeExpiresAt) {
+		return status.Error(codes.Unauthenticated, "Invalid or expired code")
+	}
+
+	reserved, err := mongodb.ReserveLoginCodeAttempt(ctx, user.Id, user.LoginCodeHash)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !reserved {
+		return status.Error(codes.ResourceExhausted, "Too many attempts, request a new code")
+	}
+
+	err = utils.VerifyPassword(code, user.LoginCodeHash)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "Invalid or expired code")
+	}
+
+	consumed, err := mongodb.ConsumeLoginCode(ctx, user.Id, user.LoginCodeHash)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !consumed {
+		return status.Error(codes.Unauthenticated, "Invalid or expired code")
+	}
+
+	return nil
+}
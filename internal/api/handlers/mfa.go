@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BeginTotpEnrollment generates a TOTP secret for the logged in user. It only takes effect after ConfirmTotpEnrollment
+func (s *Server) BeginTotpEnrollment(ctx context.Context, req *pb.EmptyRequest) (*pb.BeginTotpEnrollmentResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TotpEnabled {
+		return nil, status.Error(codes.FailedPrecondition, "TOTP is already enabled")
+	}
+
+	secret, err := utils.GenerateTotpSecret()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	err = mongodb.SetPendingTotpSecret(ctx, user.Id, secret)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	accountName := user.Email
+	if accountName == "" {
+		accountName = user.Username
+	}
+
+	return &pb.BeginTotpEnrollmentResponse{
+		Secret:     secret,
+		OtpauthUri: utils.TotpURI(secret, accountName),
+	}, nil
+}
+
+// ConfirmTotpEnrollment enables TOTP once the user submits a valid code for the pending secret
+func (s *Server) ConfirmTotpEnrollment(ctx context.Context, req *pb.ConfirmTotpEnrollmentRequest) (*pb.ConfirmTotpEnrollmentResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TotpPendingSecret == "" {
+		return nil, status.Error(codes.FailedPrecondition, "No TOTP enrollment in progress")
+	}
+
+	step, ok := utils.ValidateTotp(user.TotpPendingSecret, req.GetCode(), time.Now())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "Invalid TOTP code")
+	}
+
+	recoveryCodes, recoveryCodeHashes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	err = mongodb.EnableTotp(ctx, user.Id, user.TotpPendingSecret, step, recoveryCodeHashes)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.ConfirmTotpEnrollmentResponse{
+		Status:        true,
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// DisableTotp turns off TOTP after checking a current code
+func (s *Server) DisableTotp(ctx context.Context, req *pb.DisableTotpRequest) (*pb.DisableTotpResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.TotpEnabled {
+		return nil, status.Error(codes.FailedPrecondition, "TOTP is not enabled")
+	}
+
+	err = verifyTotpCode(ctx, user, req.GetCode())
+	if err != nil {
+		return nil, err
+	}
+
+	err = mongodb.DisableTotp(ctx, user.Id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.DisableTotpResponse{
+		Status: true,
+	}, nil
+}
+
+// VerifyMfa completes a login that was answered with an MFA challenge
+func (s *Server) VerifyMfa(ctx context.Context, req *pb.VerifyMfaRequest) (*pb.LoginResponse, error) {
+	userId, issuedFailedAttempts, err := utils.ParseMfaToken(req.GetMfaToken())
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid or expired MFA token")
+	}
+
+	user, err := mongodb.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user == nil || user.AccountStatus() == models.UserStatusDeleted {
+		return nil, status.Error(codes.Unauthenticated, "Invalid or expired MFA token")
+	}
+	utils.SetAuditActor(ctx, user.Id, user.Username)
+
+	// Wrong codes are throttled like wrong passwords, and each challenge token only allows MfaMaxAttempts of them
+	err = loginThrottleError(user.FailedLoginAttempts, user.LoginRetryAt)
+	if err != nil {
+		return nil, err
+	}
+	if user.FailedLoginAttempts-issuedFailedAttempts >= utils.MfaMaxAttempts {
+		return nil, status.Error(codes.Unauthenticated, "Too many wrong codes, sign in again")
+	}
+
+	switch {
+	case !user.TotpEnabled:
+		// Users without TOTP are only challenged for unfamiliar logins, with a code sent by email
+		err = verifyLoginCode(ctx, user, req.GetCode())
+	case req.GetCode() == "" && req.GetRecoveryCode() != "":
+		// A recovery code replaces the TOTP code when the user has lost their authenticator
+		utils.AddAuditDetail(ctx, "method", "recovery_code")
+		err = useRecoveryCode(ctx, user, req.GetRecoveryCode())
+	default:
+		err = verifyTotpCode(ctx, user, req.GetCode())
+	}
+	if err != nil {
+		if status.Code(err) != codes.Unauthenticated {
+			return nil, err
+		}
+		return nil, recordFailedCode(ctx, user.Id, err)
+	}
+
+	return signedLoginResponse(ctx, user)
+}
+
+// recordFailedCode counts a wrong MFA or emailed login code against the user's failed logins and returns the
+// error to report, the lockout error once the account is locked
+func recordFailedCode(ctx context.Context, userId string, codeErr error) error {
+	failedUser, err := mongodb.RecordFailedLogin(ctx, userId)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if utils.IsLockedOut(failedUser.FailedLoginAttempts) {
+		return loginThrottleError(failedUser.FailedLoginAttempts, failedUser.LoginRetryAt)
+	}
+	return codeErr
+}
+
+// RegenerateRecoveryCodes invalidates all existing recovery codes and returns a new set
+func (s *Server) RegenerateRecoveryCodes(ctx context.Context, req *pb.EmptyRequest) (*pb.RecoveryCodesResponse, error) {
+	err := utils.RequireRecentAuth(ctx)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.TotpEnabled {
+		return nil, status.Error(codes.FailedPrecondition, "TOTP is not enabled")
+	}
+
+	recoveryCodes, recoveryCodeHashes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	err = mongodb.SetRecoveryCodes(ctx, user.Id, recoveryCodeHashes)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.RecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// useRecoveryCode checks a recovery code and removes it so it cannot be used again
+func useRecoveryCode(ctx context.Context, user *models.User, code string) error {
+	hash, ok := utils.MatchRecoveryCode(code, user.RecoveryCodes)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "Invalid recovery code")
+	}
+
+	consumed, err := mongodb.ConsumeRecoveryCode(ctx, user.Id, hash)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !consumed {
+		return status.Error(codes.Unauthenticated, "Recovery code has already been used")
+	}
+
+	return nil
+}
+
+// verifyTotpCode validates a code against the user's enabled secret and marks it as used
+func verifyTotpCode(ctx context.Context, user *models.User, code string) error {
+	step, ok := utils.ValidateTotp(user.TotpSecret, code, time.Now())
+	if !ok {
+		return status.Error(codes.Unauthenticated, "Invalid TOTP code")
+	}
+
+	fresh, err := mongodb.ConsumeTotpStep(ctx, user.Id, step)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !fresh {
+		return status.Error(codes.Unauthenticated, "TOTP code has already been used")
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// exportAuditEventLimit caps the audit events in an export to the most recent ones
+const exportAuditEventLimit = 1000
+
+// dataExport is the JSON document returned by ExportMyData. Credentials such as password hashes,
+// TOTP secrets and recovery code hashes are never exported
+type dataExport struct {
+	ExportedAt time.Time        `json:"exported_at"`
+	Profile    exportedProfile  `json:"profile"`
+	Identities exportedIdentity `json:"identities"`
+	// Access tokens are not stored, so the devices that logged in are the only record of sessions
+	KnownDevices         []exportedDevice `json:"known_devices"`
+	AuditEvents          []exportedAudit  `json:"audit_events"`
+	AuditEventsTruncated bool             `json:"audit_events_truncated"`
+}
+
+type exportedProfile struct {
+	Id        string    `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Roles     []string  `json:"roles"`
+	Status    string    `json:"status"`
+	Picture   string    `json:"picture,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportedIdentity struct {
+	Password               bool              `json:"password"`
+	GoogleId               string            `json:"google_id,omitempty"`
+	TotpEnabled            bool              `json:"totp_enabled"`
+	RecoveryCodesRemaining int               `json:"recovery_codes_remaining"`
+	Passkeys               []exportedPasskey `json:"passkeys"`
+}
+
+type exportedPasskey struct {
+	Name       string    `json:"name,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+type exportedDevice struct {
+	UserAgent   string    `json:"user_agent"`
+	IPPrefix    string    `json:"ip_prefix"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+type exportedAudit struct {
+	EventType string            `json:"event_type"`
+	ActorId   string            `json:"actor_id,omitempty"`
+	TargetId  string            `json:"target_id,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Outcome   string            `json:"outcome"`
+	Details   map[string]string `json:"details,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// ExportMyData returns everything stored about the logged in user
+func (s *Server) ExportMyData(ctx context.Context, req *pb.EmptyRequest) (*pb.ExportMyDataResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events, truncated, err := mongodb.AuditEventsForUser(ctx, user.Id, exportAuditEventLimit)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	pbUser := publicUser(user)
+	export := dataExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: exportedProfile{
+			Id:        user.Id,
+			Username:  user.Username,
+			Email:     user.Email,
+			Roles:     user.Roles,
+			Status:    user.AccountStatus(),
+			Picture:   user.Picture,
+			CreatedAt: time.Unix(pbUser.CreatedAt, 0).UTC(),
+		},
+		Identities: exportedIdentity{
+			Password:               user.Password != "",
+			GoogleId:               user.GoogleId,
+			TotpEnabled:            user.TotpEnabled,
+			RecoveryCodesRemaining: len(user.RecoveryCodes),
+			Passkeys:               []exportedPasskey{},
+		},
+		KnownDevices:         []exportedDevice{},
+		AuditEvents:          []exportedAudit{},
+		AuditEventsTruncated: truncated,
+	}
+
+	for _, passkey := range user.Passkeys {
+		export.Identities.Passkeys = append(export.Identities.Passkeys, exportedPasskey{
+			Name:       passkey.Name,
+			CreatedAt:  passkey.CreatedAt,
+			LastUsedAt: passkey.LastUsedAt,
+		})
+	}
+	for _, device := range user.KnownDevices {
+		export.KnownDevices = append(export.KnownDevices, exportedDevice{
+			UserAgent:   device.UserAgent,
+			IPPrefix:    device.IPPrefix,
+			FirstSeenAt: device.FirstSeenAt,
+			LastSeenAt:  device.LastSeenAt,
+		})
+	}
+	for _, event := range events {
+		exported := exportedAudit{
+			EventType: event.EventType,
+			ActorId:   event.ActorId,
+			TargetId:  event.TargetId,
+			Outcome:   event.Outcome,
+			Details:   event.Details,
+			Timestamp: event.Timestamp,
+		}
+		// The network and browser of someone else acting on the user, such as an admin, are theirs, not the user's
+		if event.ActorId == user.Id {
+			exported.IP = event.IP
+			exported.UserAgent = event.UserAgent
+		}
+		export.AuditEvents = append(export.AuditEvents, exported)
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error encoding export")
+	}
+
+	return &pb.ExportMyDataResponse{
+		Data: string(data),
+	}, nil
+}
+
+// DeleteMyAccount soft deletes the logged in user's account like DeleteUser does. The user must have logged in
+// recently and, if the account has a password, confirm it
+func (s *Server) DeleteMyAccount(ctx context.Context, req *pb.DeleteMyAccountRequest) (*pb.DeleteMyAccountResponse, error) {
+	err := utils.RequireRecentAuth(ctx)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Password != "" {
+		err = utils.VerifyPassword(req.GetPassword(), user.Password)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "Incorrect password")
+		}
+	}
+
+	if slices.Contains(user.Roles, utils.RoleSuperAdmin) {
+		err = requireAnotherSuperAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deleted, err := mongodb.SoftDeleteUser(ctx, user.Id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !deleted {
+		return nil, status.Error(codes.FailedPrecondition, "Account is already deleted")
+	}
+
+	if slices.Contains(user.Roles, utils.RoleSuperAdmin) {
+		err = undoIfLastSuperAdmin(ctx, func() error {
+			return mongodb.RestoreAccountStatus(ctx, user.Id, user.AccountStatus())
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &pb.DeleteMyAccountResponse{
+		Status:  true,
+		PurgeAt: time.Now().Add(utils.UserPurgeAfter()).Unix(),
+	}, nil
+}
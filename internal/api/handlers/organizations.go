@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CreateOrganization creates an organisation. The caller becomes its first member, as super_admin
+func (s *Server) CreateOrganization(ctx context.Context, req *pb.CreateOrganizationRequest) (*pb.OrganizationResponse, error) {
+	name := strings.TrimSpace(req.GetName())
+	if name == "" || len(name) > 100 {
+		return nil, status.Error(codes.InvalidArgument, "Name must be between 1 and 100 characters")
+	}
+
+	userId, ok := ctx.Value(utils.ContextKey("userId")).(string)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized access")
+	}
+
+	now := time.Now()
+	org, err := mongodb.CreateOrganization(ctx, &models.Organization{
+		Name:      name,
+		CreatedBy: userId,
+		CreatedAt: now,
+	}, models.Membership{
+		Roles:    []string{utils.RoleSuperAdmin},
+		JoinedAt: now,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	utils.SetAuditTarget(ctx, org.Id)
+
+	return &pb.OrganizationResponse{
+		Organization: organizationToPb(org),
+	}, nil
+}
+
+// ListMyOrganizations lists the logged in user's memberships
+func (s *Server) ListMyOrganizations(ctx context.Context, req *pb.EmptyRequest) (*pb.ListMyOrganizationsResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs, err := mongodb.GetOrganizations(ctx, user.OrganizationIds())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	orgsById := make(map[string]*models.Organization, len(orgs))
+	for i := range orgs {
+		orgsById[orgs[i].Id] = &orgs[i]
+	}
+
+	response := &pb.ListMyOrganizationsResponse{}
+	for _, membership := range user.Memberships {
+		org, ok := orgsById[membership.OrgId]
+		if !ok {
+			continue
+		}
+		response.Memberships = append(response.Memberships, &pb.OrganizationMembership{
+			Organization: organizationToPb(org),
+			Roles:        membership.Roles,
+			JoinedAt:     membership.JoinedAt.Unix(),
+		})
+	}
+
+	return response, nil
+}
+
+// SwitchOrganization issues a token scoped to one of the user's organisations, carrying their roles there.
+// An empty organization_id switches back to a token with the user's global roles. The new token expires with
+// the one it replaces
+func (s *Server) SwitchOrganization(ctx context.Context, req *pb.SwitchOrganizationRequest) (*pb.LoginResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt, ok := ctx.Value(utils.ContextKey("expiresAt")).(int64)
+	if !ok {
+		return nil, status.Error(codes.Internal, "Failed to retrieve token expiry time")
+	}
+	var issuedAt time.Time
+	if issued, ok := ctx.Value(utils.ContextKey("issuedAt")).(int64); ok {
+		issuedAt = time.Unix(issued, 0)
+	}
+
+	if req.GetOrganizationId() == "" {
+		tokenString, err := utils.ReissueAccessToken(user.Id, user.Username, "", user.Roles, issuedAt, time.Unix(expiresAt, 0))
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "Could not create token")
+		}
+		return &pb.LoginResponse{
+			Status: true,
+			Token:  tokenString,
+		}, nil
+	}
+
+	membership := user.Membership(req.GetOrganizationId())
+	if membership == nil {
+		return nil, status.Error(codes.NotFound, "Organization not found")
+	}
+	org, err := mongodb.GetOrganization(ctx, membership.OrgId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if org == nil {
+		return nil, status.Error(codes.NotFound, "Organization not found")
+	}
+	utils.AddAuditDetail(ctx, "to_org_id", org.Id)
+
+	tokenString, err := utils.ReissueAccessToken(user.Id, user.Username, org.Id, membership.Roles, issuedAt, time.Unix(expiresAt, 0))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Could not create token")
+	}
+
+	return &pb.LoginResponse{
+		Status: true,
+		Token:  tokenString,
+	}, nil
+}
+
+// RemoveOrganizationMember removes a user from the caller's organisation. Their account is left untouched
+func (s *Server) RemoveOrganizationMember(ctx context.Context, req *pb.OrganizationMemberRequest) (*pb.OrganizationMemberResponse, error) {
+	orgId := utils.CallerOrganization(ctx)
+
+	user, err := targetUser(ctx, utils.PermOrgMembersWrite, req.GetId(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	if membership := user.Membership(orgId); membership != nil && containsRole(membership.Roles, utils.RoleSuperAdmin) {
+		err = requireAnotherOrgSuperAdmin(ctx, orgId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	removed, err := mongodb.RemoveMembership(ctx, user.Id, orgId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !removed {
+		return nil, status.Error(codes.NotFound, "User not found")
+	}
+
+	if membership := user.Membership(orgId); membership != nil && containsRole(membership.Roles, utils.RoleSuperAdmin) {
+		err = undoIfLastOrgSuperAdmin(ctx, orgId, func() error {
+			_, err := mongodb.AddMembership(ctx, user.Id, *membership)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &pb.OrganizationMemberResponse{
+		Status: true,
+	}, nil
+}
+
+// changeMemberRoles is ChangeRole for callers scoped to an organisation: it replaces the user's roles there
+// rather than their global roles
+func changeMemberRoles(ctx context.Context, orgId string, user *models.User, updatedRoles []string) (*pb.ChangeRoleResponse, error) {
+	membership := user.Membership(orgId)
+	if membership == nil {
+		return nil, status.Error(codes.NotFound, "User not found")
+	}
+	if containsRole(membership.Roles, utils.RoleSuperAdmin) && !containsRole(updatedRoles, utils.RoleSuperAdmin) {
+		err := requireAnotherOrgSuperAdmin(ctx, orgId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	previousUser, err := mongodb.SetMembershipRoles(ctx, user.Id, orgId, updatedRoles)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if previousUser == nil || previousUser.Membership(orgId) == nil {
+		return nil, status.Error(codes.NotFound, "User not found")
+	}
+
+	previousRoles := previousUser.Membership(orgId).Roles
+	utils.AddAuditDetail(ctx, "old_roles", strings.Join(previousRoles, ","))
+
+	if containsRole(previousRoles, utils.RoleSuperAdmin) && !containsRole(updatedRoles, utils.RoleSuperAdmin) {
+		err = undoIfLastOrgSuperAdmin(ctx, orgId, func() error {
+			_, err := mongodb.SetMembershipRoles(ctx, user.Id, orgId, previousRoles)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &pb.ChangeRoleResponse{
+		Status: true,
+	}, nil
+}
+
+// memberRoles validates the roles given to a new member or by ChangeRole, user by default. Callers can only hand
+// out roles whose permissions they hold themselves
+func memberRoles(ctx context.Context, roles []string) ([]string, error) {
+	if len(roles) == 0 {
+		return []string{utils.RoleUser}, nil
+	}
+
+	for _, role := range roles {
+		if !utils.RoleRegistry.Exists(role) {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Unknown role %q, must be one of %s", role, strings.Join(utils.RoleRegistry.Names(), ", ")))
+		}
+		for _, permission := range utils.RoleRegistry.EffectivePermissions(role) {
+			err := utils.RequirePermission(ctx, permission)
+			if err != nil {
+				return nil, status.Error(codes.PermissionDenied, "Cannot grant role "+role+": "+err.Error())
+			}
+		}
+	}
+	return roles, nil
+}
+
+// requireAnotherOrgSuperAdmin keeps organisations from losing their last super_admin
+func requireAnotherOrgSuperAdmin(ctx context.Context, orgId string) error {
+	superAdmins, err := mongodb.CountActiveMembersWithRole(ctx, orgId, utils.RoleSuperAdmin)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if superAdmins <= 1 {
+		return status.Error(codes.FailedPrecondition, "Cannot remove the last super_admin of the organization")
+	}
+	return nil
+}
+
+// undoIfLastOrgSuperAdmin is undoIfLastSuperAdmin for the super admins of an organisation
+func undoIfLastOrgSuperAdmin(ctx context.Context, orgId string, undo func() error) error {
+	superAdmins, err := mongodb.CountActiveMembersWithRole(ctx, orgId, utils.RoleSuperAdmin)
+	return undoIfNoneLeft(superAdmins, err, "Cannot remove the last super_admin of the organization", undo)
+}
+
+// visibleToCaller reports whether a user is within the caller's tenant: everyone for global tokens, members of
+// the organisation for tokens scoped to one
+func visibleToCaller(ctx context.Context, user *models.User) bool {
+	return visibleInOrganization(utils.CallerOrganization(ctx), user)
+}
+
+// visibleInOrganization is visibleToCaller for a token scoped to orgId, or a global token when it is empty
+func visibleInOrganization(orgId string, user *models.User) bool {
+	return orgId == "" || user.Membership(orgId) != nil
+}
+
+// scopedRoles returns the user's roles in the caller's organisation, or their global roles
+func scopedRoles(ctx context.Context, user *models.User) []string {
+	return organizationRoles(utils.CallerOrganization(ctx), user)
+}
+
+// organizationRoles returns the user's roles in an organisation, or their global roles when orgId is empty
+func organizationRoles(orgId string, user *models.User) []string {
+	if orgId == "" {
+		return user.Roles
+	}
+	if membership := user.Membership(orgId); membership != nil {
+		return membership.Roles
+	}
+	return nil
+}
+
+func containsRole(roles []string, role string) bool {
+	for _, candidate := range roles {
+		if candidate == role {
+			return true
+		}
+	}
+	return false
+}
+
+func organizationToPb(org *models.Organization) *pb.Organization {
+	return &pb.Organization{
+		Id:        org.Id,
+		Name:      org.Name,
+		CreatedAt: org.CreatedAt.Unix(),
+	}
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// passkeyUser adapts models.User to the webauthn.User interface. The user handle is the user id
+type passkeyUser struct {
+	*models.User
+}
+
+func (u passkeyUser) WebAuthnID() []byte {
+	return []byte(u.Id)
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	if u.Email != "" {
+		return u.Email
+	}
+	return u.Username
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	return u.Username
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.Passkeys))
+	for _, passkey := range u.Passkeys {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(passkey.Transports))
+		for _, transport := range passkey.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              passkey.CredentialId,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				UserPresent:    true,
+				UserVerified:   passkey.UserVerified,
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    passkey.AAGUID,
+				SignCount: passkey.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+// BeginPasskeyRegistration returns the credential creation options for the logged in user
+func (s *Server) BeginPasskeyRegistration(ctx context.Context, req *pb.EmptyRequest) (*pb.PasskeyCeremonyResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wa, err := utils.NewWebAuthn()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	webAuthnUser := passkeyUser{user}
+	creation, session, err := wa.BeginRegistration(webAuthnUser,
+		webauthn.WithExclusions(webauthn.Credentials(webAuthnUser.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Could not start passkey registration")
+	}
+
+	return passkeyCeremonyResponse(user.Id, creation, session)
+}
+
+// FinishPasskeyRegistration verifies the attestation sent by the browser and stores the new passkey
+func (s *Server) FinishPasskeyRegistration(ctx context.Context, req *pb.FinishPasskeyRegistrationRequest) (*pb.FinishPasskeyRegistrationResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session, ok := utils.PasskeySessions.Take(req.GetSessionId())
+	if !ok || session.UserId != user.Id {
+		return nil, status.Error(codes.InvalidArgument, "Invalid or expired passkey session")
+	}
+
+	parsedResponse, err := protocol.ParseCredentialCreationResponseBytes([]byte(req.GetCredentialJson()))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid passkey credential")
+	}
+
+	wa, err := utils.NewWebAuthn()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	credential, err := wa.CreateCredential(passkeyUser{user}, session.Data, parsedResponse)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Passkey verification failed")
+	}
+
+	passkey := passkeyFromCredential(credential, req.GetName())
+
+	added, err := mongodb.AddPasskey(ctx, user.Id, passkey)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !added {
+		return nil, status.Error(codes.AlreadyExists, "Passkey is already registered")
+	}
+
+	return &pb.FinishPasskeyRegistrationResponse{
+		Status: true,
+	}, nil
+}
+
+// BeginPasskeyLogin returns the assertion options. Without a username the browser offers any discoverable passkey
+func (s *Server) BeginPasskeyLogin(ctx context.Context, req *pb.BeginPasskeyLoginRequest) (*pb.PasskeyCeremonyResponse, error) {
+	wa, err := utils.NewWebAuthn()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req.GetUsername() == "" {
+		assertion, session, err := wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			return nil, status.Error(codes.Internal, "Could not start passkey login")
+		}
+		return passkeyCeremonyResponse("", assertion, session)
+	}
+
+	user, err := s.Accounts.GetUserByUsername(ctx, req.GetUsername())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user == nil || user.AccountStatus() == models.UserStatusDeleted || len(user.Passkeys) == 0 {
+		// Answer with a challenge like any other so the response does not reveal whether the username exists
+		user, err = unknownPasskeyUser(req.GetUsername())
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	assertion, session, err := wa.BeginLogin(passkeyUser{user}, webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Could not start passkey login")
+	}
+	return passkeyCeremonyResponse(user.Id, assertion, session)
+}
+
+// unknownPasskeyUser stands in for usernames without passkeys. Its credential is derived from the username, so
+// repeated requests see the same allowed credential, and its id is not an ObjectID, so the session it starts
+// can never be finished
+func unknownPasskeyUser(username string) (*models.User, error) {
+	credentialId, err := utils.DecoyCredentialId(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.User{
+		Id:       "unknown:" + username,
+		Username: username,
+		Passkeys: []models.Passkey{{
+			CredentialId: credentialId,
+			Transports:   []string{string(protocol.Internal), string(protocol.Hybrid)},
+		}},
+	}, nil
+}
+
+// FinishPasskeyLogin verifies the assertion signature and issues an access token.
+// User verification is required, so the passkey already counts as two factors and no TOTP challenge follows
+func (s *Server) FinishPasskeyLogin(ctx context.Context, req *pb.FinishPasskeyLoginRequest) (*pb.LoginResponse, error) {
+	session, ok := utils.PasskeySessions.Take(req.GetSessionId())
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "Invalid or expired passkey session")
+	}
+
+	parsedResponse, err := protocol.ParseCredentialRequestResponseBytes([]byte(req.GetCredentialJson()))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid passkey assertion")
+	}
+
+	wa, err := utils.NewWebAuthn()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// The credential id identifies the owner for both discoverable and username-first logins
+	user, err := mongodb.GetUserByPasskeyCredentialId(ctx, parsedResponse.RawID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user == nil {
+		return nil, status.Error(codes.Unauthenticated, "Passkey verification failed")
+	}
+	utils.SetAuditActor(ctx, user.Id, user.Username)
+
+	var credential *webauthn.Credential
+	if session.UserId == "" {
+		_, credential, err = wa.ValidatePasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			if !bytes.Equal(userHandle, []byte(user.Id)) {
+				return nil, protocol.ErrBadRequest.WithDetails("User handle does not match credential owner")
+			}
+			return passkeyUser{user}, nil
+		}, session.Data, parsedResponse)
+	} else {
+		if session.UserId != user.Id {
+			return nil, status.Error(codes.Unauthenticated, "Passkey verification failed")
+		}
+		credential, err = wa.ValidateLogin(passkeyUser{user}, session.Data, parsedResponse)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Passkey verification failed")
+	}
+
+	// A signature counter that went backwards means the credential may have been cloned
+	if credential.Authenticator.CloneWarning {
+		return nil, status.Error(codes.Unauthenticated, "Passkey verification failed")
+	}
+
+	err = mongodb.UpdatePasskeyUsage(ctx, user.Id, credential.ID, credential.Authenticator.SignCount, credential.Flags.BackupState)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return signedLoginResponse(ctx, user)
+}
+
+// passkeyFromCredential converts a verified credential into the passkey stored on the user
+func passkeyFromCredential(credential *webauthn.Credential, name string) models.Passkey {
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	return models.Passkey{
+		CredentialId:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		Name:            name,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		UserVerified:    credential.Flags.UserVerified,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	}
+}
+
+// passkeyCeremonyResponse stores the ceremony state and serialises the options for the browser
+func passkeyCeremonyResponse(userId string, options any, session *webauthn.SessionData) (*pb.PasskeyCeremonyResponse, error) {
+	sessionId, err := utils.PasskeySessions.Add(utils.PasskeySession{
+		UserId: userId,
+		Data:   *session,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	optionsJson, err := json.Marshal(options)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Could not encode passkey options")
+	}
+
+	return &pb.PasskeyCeremonyResponse{
+		SessionId:   sessionId,
+		OptionsJson: string(optionsJson),
+	}, nil
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"slices"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softwareAuthenticator is a minimal platform authenticator: one P-256 key, "none" attestation,
+// user presence and verification always asserted
+type softwareAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialId []byte
+	signCount    uint32
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialId := make([]byte, 16)
+	_, err = rand.Read(credentialId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softwareAuthenticator{key: key, credentialId: credentialId}
+}
+
+func (a *softwareAuthenticator) authenticatorData(flags byte, attestedCredential []byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(testRPID))
+
+	data := append([]byte{}, rpIdHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attestedCredential...)
+}
+
+func clientData(t *testing.T, ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge.String(),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// create answers navigator.credentials.create() and returns the JSON a browser would send to FinishPasskeyRegistration
+func (a *softwareAuthenticator) create(t *testing.T, creation *protocol.CredentialCreation) []byte {
+	t.Helper()
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attestedCredential := make([]byte, 16) // AAGUID
+	attestedCredential = binary.BigEndian.AppendUint16(attestedCredential, uint16(len(a.credentialId)))
+	attestedCredential = append(attestedCredential, a.credentialId...)
+	attestedCredential = append(attestedCredential, publicKey...)
+
+	const flags = 0x01 | 0x04 | 0x40 // user present, user verified, attested credential data
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(flags, attestedCredential),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credentialJson(t, a.credentialId, map[string]any{
+		"clientDataJSON":    encode(clientData(t, "webauthn.create", creation.Response.Challenge)),
+		"attestationObject": encode(attestationObject),
+		"transports":        []string{string(protocol.Internal), string(protocol.Hybrid)},
+	})
+}
+
+// get answers navigator.credentials.get() and returns the JSON a browser would send to FinishPasskeyLogin
+func (a *softwareAuthenticator) get(t *testing.T, assertion *protocol.CredentialAssertion, userHandle string) []byte {
+	t.Helper()
+
+	a.signCount++
+	const flags = 0x01 | 0x04 // user present, user verified
+	authData := a.authenticatorData(flags, nil)
+	clientDataJson := clientData(t, "webauthn.get", assertion.Response.Challenge)
+
+	clientDataHash := sha256.Sum256(clientDataJson)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credentialJson(t, a.credentialId, map[string]any{
+		"clientDataJSON":    encode(clientDataJson),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode([]byte(userHandle)),
+	})
+}
+
+func credentialJson(t *testing.T, credentialId []byte, response map[string]any) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]any{
+		"id":       encode(credentialId),
+		"rawId":    encode(credentialId),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func testWebAuthn(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+
+	t.Setenv("WEBAUTHN_RP_ID", testRPID)
+	t.Setenv("WEBAUTHN_RP_ORIGINS", testOrigin)
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	wa, err := utils.NewWebAuthn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wa
+}
+
+// registerPasskey runs the registration ceremony and returns the passkey as it would be stored on the user
+func registerPasskey(t *testing.T, wa *webauthn.WebAuthn, user *models.User, authenticator *softwareAuthenticator) models.Passkey {
+	t.Helper()
+
+	creation, session, err := wa.BeginRegistration(passkeyUser{user},
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(authenticator.create(t, creation))
+	if err != nil {
+		t.Fatalf("parsing attestation: %v", err)
+	}
+	credential, err := wa.CreateCredential(passkeyUser{user}, *session, parsed)
+	if err != nil {
+		t.Fatalf("verifying attestation: %v", err)
+	}
+
+	return passkeyFromCredential(credential, "laptop")
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	wa := testWebAuthn(t)
+	user := &models.User{Id: "64b7f0c2a1b2c3d4e5f60718", Username: "alice", Email: "alice@example.com"}
+	authenticator := newSoftwareAuthenticator(t)
+
+	passkey := registerPasskey(t, wa, user, authenticator)
+	if !bytes.Equal(passkey.CredentialId, authenticator.credentialId) || !passkey.UserVerified {
+		t.Fatalf("unexpected stored passkey: %+v", passkey)
+	}
+	user.Passkeys = []models.Passkey{passkey}
+
+	// Login uses the stored passkey as read back through passkeyUser
+	assertion, session, err := wa.BeginLogin(passkeyUser{user}, webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(authenticator.get(t, assertion, user.Id))
+	if err != nil {
+		t.Fatalf("parsing assertion: %v", err)
+	}
+	credential, err := wa.ValidateLogin(passkeyUser{user}, *session, parsed)
+	if err != nil {
+		t.Fatalf("verifying assertion: %v", err)
+	}
+	if credential.Authenticator.SignCount != 1 || credential.Authenticator.CloneWarning {
+		t.Fatalf("unexpected sign count %d (clone warning %v)", credential.Authenticator.SignCount, credential.Authenticator.CloneWarning)
+	}
+}
+
+func TestPasskeyLoginRejectsOtherKey(t *testing.T) {
+	wa := testWebAuthn(t)
+	user := &models.User{Id: "64b7f0c2a1b2c3d4e5f60718", Username: "alice"}
+	authenticator := newSoftwareAuthenticator(t)
+	user.Passkeys = []models.Passkey{registerPasskey(t, wa, user, authenticator)}
+
+	// Same credential id, different private key: the signature must not verify
+	impostor := newSoftwareAuthenticator(t)
+	impostor.credentialId = authenticator.credentialId
+
+	assertion, session, err := wa.BeginLogin(passkeyUser{user}, webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(impostor.get(t, assertion, user.Id))
+	if err != nil {
+		t.Fatalf("parsing assertion: %v", err)
+	}
+	_, err = wa.ValidateLogin(passkeyUser{user}, *session, parsed)
+	if err == nil {
+		t.Fatal("assertion signed by another key was accepted")
+	}
+}
+
+func TestBeginPasskeyLoginForUnknownUserLooksLikeKnownUser(t *testing.T) {
+	wa := testWebAuthn(t)
+	known := &models.User{Id: "64b7f0c2a1b2c3d4e5f60718", Username: "alice"}
+	known.Passkeys = []models.Passkey{registerPasskey(t, wa, known, newSoftwareAuthenticator(t))}
+	s := &Server{Accounts: fakeAccounts{"alice": known}}
+
+	options := func(username string) (*protocol.CredentialAssertion, map[string]any) {
+		t.Helper()
+		response, err := s.BeginPasskeyLogin(context.Background(), &pb.BeginPasskeyLoginRequest{Username: username})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var assertion protocol.CredentialAssertion
+		var fields map[string]any
+		err = errors.Join(
+			json.Unmarshal([]byte(response.GetOptionsJson()), &assertion),
+			json.Unmarshal([]byte(response.GetOptionsJson()), &fields),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &assertion, fields
+	}
+
+	knownOptions, knownFields := options("alice")
+	unknownOptions, unknownFields := options("mallory")
+
+	// Apart from the challenge and the credential id the options carry the same fields
+	if len(knownOptions.Response.AllowedCredentials) != 1 || len(unknownOptions.Response.AllowedCredentials) != 1 {
+		t.Fatalf("unknown user offers %d credentials, known user %d",
+			len(unknownOptions.Response.AllowedCredentials), len(knownOptions.Response.AllowedCredentials))
+	}
+	knownCredential := knownOptions.Response.AllowedCredentials[0]
+	unknownCredential := unknownOptions.Response.AllowedCredentials[0]
+	if len(unknownCredential.CredentialID) != len(knownCredential.CredentialID) {
+		t.Fatalf("decoy credential id is %d bytes, real one %d", len(unknownCredential.CredentialID), len(knownCredential.CredentialID))
+	}
+	if !slices.Equal(unknownCredential.Transport, knownCredential.Transport) || unknownCredential.Type != knownCredential.Type {
+		t.Fatalf("decoy credential %+v does not look like real one %+v", unknownCredential, knownCredential)
+	}
+	if unknownOptions.Response.UserVerification != knownOptions.Response.UserVerification ||
+		unknownOptions.Response.RelyingPartyID != knownOptions.Response.RelyingPartyID ||
+		unknownOptions.Response.Timeout != knownOptions.Response.Timeout {
+		t.Fatal("assertion options differ between known and unknown users")
+	}
+	knownJson, _ := json.Marshal(knownFields["publicKey"].(map[string]any)["allowCredentials"])
+	unknownJson, _ := json.Marshal(unknownFields["publicKey"].(map[string]any)["allowCredentials"])
+	if len(knownJson) != len(unknownJson) {
+		t.Fatalf("allowed credentials encode to %d bytes for the unknown user, %d for the known user", len(unknownJson), len(knownJson))
+	}
+
+	// The decoy credential is stable, so asking twice does not give the unknown username away
+	again, _ := options("mallory")
+	if !bytes.Equal(unknownCredential.CredentialID, again.Response.AllowedCredentials[0].CredentialID) {
+		t.Fatal("decoy credential id changes between requests")
+	}
+}
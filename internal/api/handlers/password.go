@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"goAuth/internal/api/interceptors"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ChangePassword lets the logged in user replace their password after confirming the current one
+func (s *Server) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	if req.GetNewPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "New password is required")
+	}
+
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Password == "" {
+		return nil, status.Error(codes.FailedPrecondition, "Account does not have a password")
+	}
+
+	err = utils.VerifyPassword(req.GetCurrentPassword(), user.Password)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Incorrect password")
+	}
+
+	err = replacePassword(ctx, user, req.GetNewPassword(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ChangePasswordResponse{
+		Status: true,
+	}, nil
+}
+
+// replacePassword enforces the password history and stores a new password. Every path that sets a password a user
+// chose goes through it, including any reset flow, so the reuse check cannot be bypassed. The update only applies
+// if the password is still the one that was checked, so concurrent changes cannot skip the history. revokeTokens
+// signs the user out everywhere
+func replacePassword(ctx context.Context, user *models.User, newPassword string, revokeTokens bool) error {
+	historySize := utils.PasswordHistorySize()
+	if utils.IsPasswordReused(newPassword, user.Password, user.PasswordHistory, historySize) {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("New password must not match any of your last %d passwords", historySize))
+	}
+
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return status.Error(codes.Internal, "Error hashing password")
+	}
+
+	changed, err := mongodb.ChangeUserPassword(ctx, user.Id, user.Password, hashedPassword, historySize, revokeTokens)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !changed {
+		return status.Error(codes.Aborted, "Password was changed concurrently, try again")
+	}
+	return nil
+}
+
+// RequestPasswordReset emails a reset token. The lookup and delivery happen in the background so the response,
+// and how long it takes, is the same whether or not the email is registered
+func (s *Server) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetResponse, error) {
+	if req.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Email is required")
+	}
+
+	go sendPasswordReset(req.GetEmail())
+
+	return &pb.RequestPasswordResetResponse{Status: true}, nil
+}
+
+// sendPasswordReset emails a reset token if the email belongs to an account that can sign in with a password.
+// Accounts without one, e.g. Google only, have nothing to reset
+func sendPasswordReset(email string) {
+	ctx := context.Background()
+
+	user, err := mongodb.GetUserByEmail(ctx, email)
+	if err != nil {
+		utils.ErrorHandler(err, "Error looking up password reset")
+		return
+	}
+	if user == nil || user.Password == "" || interceptors.AccountStatusError(user) != nil {
+		return
+	}
+
+	token, err := utils.SignPasswordResetToken(user.Id, user.Password)
+	if err != nil {
+		utils.ErrorHandler(err, "Error creating password reset token")
+		return
+	}
+
+	body := "Use the link below to choose a new password. It expires in 30 minutes and can only be used once.\n\n" + utils.PasswordResetLink(token)
+	utils.NotifyAsync(user.Email, "Reset your password", body)
+}
+
+// ResetPassword sets a new password with an emailed reset token, subject to the same password history as
+// ChangePassword, and revokes the user's access tokens
+func (s *Server) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.ChangePasswordResponse, error) {
+	if req.GetNewPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "New password is required")
+	}
+
+	userId, fingerprint, err := utils.ParsePasswordResetToken(req.GetToken())
+	if err != nil {
+		return nil, errInvalidResetToken
+	}
+
+	user, err := mongodb.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user == nil || user.Password == "" || !utils.PasswordResetTokenCurrent(fingerprint, user.Password) {
+		return nil, errInvalidResetToken
+	}
+	utils.SetAuditActor(ctx, user.Id, user.Username)
+
+	err = interceptors.AccountStatusError(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Whoever knew the old password may hold a session, so a reset signs out every device
+	err = replacePassword(ctx, user, req.GetNewPassword(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ChangePasswordResponse{
+		Status: true,
+	}, nil
+}
+
+// errInvalidResetToken is the only error ResetPassword returns for unknown, expired or used tokens
+var errInvalidResetToken = status.Error(codes.Unauthenticated, "Invalid or expired reset token")
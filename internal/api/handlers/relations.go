@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Most tuples a single WriteTuples call may change
+const maxTupleWrites = 100
+
+// WriteTuples adds and removes relation tuples as one revision. Reads only see the revision once every change
+// of the call is stored, and the returned token covers all of them
+func (s *Server) WriteTuples(ctx context.Context, req *pb.WriteTuplesRequest) (*pb.WriteTuplesResponse, error) {
+	if len(req.GetWrites())+len(req.GetDeletes()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Nothing to write")
+	}
+	if len(req.GetWrites())+len(req.GetDeletes()) > maxTupleWrites {
+		return nil, status.Error(codes.InvalidArgument, "At most "+strconv.Itoa(maxTupleWrites)+" tuples can be changed at once")
+	}
+
+	writes, err := s.parseTuples(req.GetWrites())
+	if err != nil {
+		return nil, err
+	}
+	deletes, err := s.parseTuples(req.GetDeletes())
+	if err != nil {
+		return nil, err
+	}
+
+	revision, writtenAt, err := s.Relations.Store.Write(ctx, writes, deletes)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	utils.AddAuditDetail(ctx, "writes", strconv.Itoa(len(writes)))
+	utils.AddAuditDetail(ctx, "deletes", strconv.Itoa(len(deletes)))
+	utils.AddAuditDetail(ctx, "revision", strconv.FormatInt(revision, 10))
+
+	token, err := consistencyToken(revision, writtenAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.WriteTuplesResponse{
+		ConsistencyToken: token,
+	}, nil
+}
+
+// Check reports whether a subject has a relation on an object
+func (s *Server) Check(ctx context.Context, req *pb.CheckRequest) (*pb.CheckResponse, error) {
+	object, err := s.parseObjectRelation(req.GetObject(), req.GetRelation())
+	if err != nil {
+		return nil, err
+	}
+	subject, err := s.parseSubject(req.GetSubject())
+	if err != nil {
+		return nil, err
+	}
+
+	revision, writtenAt, err := s.relationSnapshot(ctx, req.GetConsistencyToken(), req.GetAtExactSnapshot())
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := s.Relations.Check(ctx, object, req.GetRelation(), subject, revision)
+	if err != nil {
+		return nil, relationError(err)
+	}
+
+	token, err := consistencyToken(revision, writtenAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CheckResponse{
+		Allowed:          allowed,
+		ConsistencyToken: token,
+	}, nil
+}
+
+// Expand returns everyone with a relation on an object as a userset tree
+func (s *Server) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	object, err := s.parseObjectRelation(req.GetObject(), req.GetRelation())
+	if err != nil {
+		return nil, err
+	}
+
+	revision, writtenAt, err := s.relationSnapshot(ctx, req.GetConsistencyToken(), req.GetAtExactSnapshot())
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := s.Relations.Expand(ctx, object, req.GetRelation(), revision)
+	if err != nil {
+		return nil, relationError(err)
+	}
+
+	token, err := consistencyToken(revision, writtenAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ExpandResponse{
+		Tree:             usersetTreeToPb(tree),
+		ConsistencyToken: token,
+	}, nil
+}
+
+// ListObjects lists the objects of a namespace a subject has a relation on
+func (s *Server) ListObjects(ctx context.Context, req *pb.ListObjectsRequest) (*pb.ListObjectsResponse, error) {
+	if _, ok := s.Relations.Schema.Relation(req.GetNamespace(), req.GetRelation()); !ok {
+		return nil, status.Error(codes.InvalidArgument, "Namespace "+strconv.Quote(req.GetNamespace())+" has no relation "+strconv.Quote(req.GetRelation()))
+	}
+	subject, err := s.parseSubject(req.GetSubject())
+	if err != nil {
+		return nil, err
+	}
+
+	revision, writtenAt, err := s.relationSnapshot(ctx, req.GetConsistencyToken(), req.GetAtExactSnapshot())
+	if err != nil {
+		return nil, err
+	}
+
+	objectIds, err := s.Relations.ListObjects(ctx, req.GetNamespace(), req.GetRelation(), subject, revision)
+	if err != nil {
+		return nil, relationError(err)
+	}
+
+	token, err := consistencyToken(revision, writtenAt)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &pb.ListObjectsResponse{
+		Objects:          make([]string, 0, len(objectIds)),
+		ConsistencyToken: token,
+	}
+	for _, objectId := range objectIds {
+		response.Objects = append(response.Objects, utils.RelationObject{Namespace: req.GetNamespace(), Id: objectId}.String())
+	}
+	return response, nil
+}
+
+func (s *Server) parseTuples(values []string) ([]utils.RelationTuple, error) {
+	tuples := make([]utils.RelationTuple, 0, len(values))
+	for _, value := range values {
+		tuple, err := utils.ParseRelationTuple(value)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		err = s.Relations.Schema.ValidateTuple(tuple)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		tuples = append(tuples, tuple)
+	}
+	return tuples, nil
+}
+
+func (s *Server) parseObjectRelation(value, relation string) (utils.RelationObject, error) {
+	object, err := utils.ParseRelationObject(value)
+	if err != nil {
+		return utils.RelationObject{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, ok := s.Relations.Schema.Relation(object.Namespace, relation); !ok {
+		return utils.RelationObject{}, status.Error(codes.InvalidArgument, "Namespace "+strconv.Quote(object.Namespace)+" has no relation "+strconv.Quote(relation))
+	}
+	return object, nil
+}
+
+func (s *Server) parseSubject(value string) (utils.RelationSubject, error) {
+	subject, err := utils.ParseRelationSubject(value)
+	if err != nil {
+		return utils.RelationSubject{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = s.Relations.Schema.ValidateSubject(subject)
+	if err != nil {
+		return utils.RelationSubject{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return subject, nil
+}
+
+func (s *Server) relationSnapshot(ctx context.Context, token string, exact bool) (int64, time.Time, error) {
+	if exact && token == "" {
+		return 0, time.Time{}, status.Error(codes.InvalidArgument, "at_exact_snapshot requires a consistency token")
+	}
+	revision, writtenAt, err := s.Relations.Snapshot(ctx, token, exact)
+	if err != nil {
+		return 0, time.Time{}, relationError(err)
+	}
+	return revision, writtenAt, nil
+}
+
+// consistencyToken returns the token for the revision a response was evaluated at
+func consistencyToken(revision int64, writtenAt time.Time) (string, error) {
+	token, err := utils.EncodeConsistencyToken(revision, writtenAt)
+	if err != nil {
+		return "", status.Error(codes.Internal, "Could not create consistency token")
+	}
+	return token, nil
+}
+
+// relationError maps the errors of the relation engine to status codes
+func relationError(err error) error {
+	switch {
+	case errors.Is(err, utils.ErrInvalidConsistencyToken):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, utils.ErrConsistencyTokenAhead):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, utils.ErrSnapshotExpired), errors.Is(err, utils.ErrRelationDepth):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func usersetTreeToPb(tree *utils.UsersetTree) *pb.UsersetTree {
+	node := &pb.UsersetTree{
+		Userset:  tree.Userset,
+		Subjects: tree.Subjects,
+	}
+	for _, child := range tree.Children {
+		node.Children = append(node.Children, usersetTreeToPb(child))
+	}
+	return node
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"slices"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CreateRole adds a new role
+func (s *Server) CreateRole(ctx context.Context, req *pb.RoleRequest) (*pb.RoleResponse, error) {
+	role := roleFromRequest(req)
+	if utils.RoleRegistry.Exists(role.Name) {
+		return nil, status.Error(codes.AlreadyExists, "Role already exists")
+	}
+
+	err := checkRoleChange(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := mongodb.CreateRole(ctx, role)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !created {
+		return nil, status.Error(codes.AlreadyExists, "Role already exists")
+	}
+
+	return savedRoleResponse(ctx, role.Name)
+}
+
+// UpdateRole replaces the description, permissions and inherited roles of an existing role.
+// super_admin cannot be edited so that there is always a role able to manage everything
+func (s *Server) UpdateRole(ctx context.Context, req *pb.RoleRequest) (*pb.RoleResponse, error) {
+	role := roleFromRequest(req)
+	existing, ok := utils.RoleRegistry.Get(role.Name)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Role not found")
+	}
+	if existing.Name == utils.RoleSuperAdmin {
+		return nil, status.Error(codes.FailedPrecondition, "The super_admin role cannot be changed")
+	}
+	role.BuiltIn = existing.BuiltIn
+
+	// Built-in roles get their default permissions back on every startup, so removing them would not stick
+	for _, defaultRole := range utils.DefaultRoles {
+		if defaultRole.Name != role.Name {
+			continue
+		}
+		for _, permission := range defaultRole.Permissions {
+			if !slices.Contains(role.Permissions, permission) {
+				return nil, status.Error(codes.FailedPrecondition, "Built-in role "+role.Name+" must keep permission "+permission)
+			}
+		}
+	}
+
+	err := checkRoleChange(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := mongodb.UpdateRole(ctx, role)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !updated {
+		return nil, status.Error(codes.NotFound, "Role not found")
+	}
+
+	return savedRoleResponse(ctx, role.Name)
+}
+
+// ListRoles returns every role and every permission that can be granted
+func (s *Server) ListRoles(ctx context.Context, req *pb.EmptyRequest) (*pb.ListRolesResponse, error) {
+	response := &pb.ListRolesResponse{
+		Permissions: utils.AllPermissions,
+	}
+	for _, role := range utils.RoleRegistry.All() {
+		response.Roles = append(response.Roles, roleToPb(role))
+	}
+
+	return response, nil
+}
+
+func roleFromRequest(req *pb.RoleRequest) utils.RoleDefinition {
+	return utils.RoleDefinition{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Permissions: req.GetPermissions(),
+		Inherits:    req.GetInherits(),
+	}
+}
+
+// checkRoleChange validates a role and makes sure callers cannot grant permissions they do not hold themselves
+func checkRoleChange(ctx context.Context, role utils.RoleDefinition) error {
+	err := utils.RoleRegistry.WithRole(role)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	granted := append([]string{}, role.Permissions...)
+	for _, parent := range role.Inherits {
+		granted = append(granted, utils.RoleRegistry.EffectivePermissions(parent)...)
+	}
+	for _, permission := range granted {
+		err = utils.RequirePermission(ctx, permission)
+		if err != nil {
+			return status.Error(codes.PermissionDenied, "Cannot grant "+permission+" without holding it")
+		}
+	}
+
+	return nil
+}
+
+// savedRoleResponse reloads the registry so the change applies on this replica immediately; other replicas
+// pick it up within utils.RoleRefreshInterval
+func savedRoleResponse(ctx context.Context, name string) (*pb.RoleResponse, error) {
+	err := mongodb.RefreshRoleRegistry(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	role, ok := utils.RoleRegistry.Get(name)
+	if !ok {
+		return nil, status.Error(codes.Internal, "Role was saved but could not be read back")
+	}
+
+	return &pb.RoleResponse{
+		Role: roleToPb(role),
+	}, nil
+}
+
+func roleToPb(role utils.RoleDefinition) *pb.Role {
+	return &pb.Role{
+		Name:                 role.Name,
+		Description:          role.Description,
+		Permissions:          role.Permissions,
+		Inherits:             role.Inherits,
+		EffectivePermissions: utils.RoleRegistry.EffectivePermissions(role.Name),
+		BuiltIn:              role.BuiltIn,
+	}
+}
@@ -1,7 +1,30 @@
 package handlers
 
-import pb "goAuth/proto/gen"
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+)
 
 type Server struct {
 	pb.UnimplementedAuthServiceServer
+
+	// Relations evaluates relation tuples for WriteTuples, Check, Expand and ListObjects
+	Relations *utils.RelationEngine
+
+	// UnknownUserLockouts throttles failed logins for usernames that do not exist
+	UnknownUserLockouts utils.UnknownUserLockoutStore
+
+	// Accounts looks users up for the login rpcs
+	Accounts AccountStore
+}
+
+// AccountStore is the part of the user storage the login rpcs use, so they can run against a fake in tests
+type AccountStore interface {
+	// GetUserByUsername returns nil without an error when no account has the username
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+
+	// RecordFailedLogin counts a failed attempt and returns the updated user, see mongodb.RecordFailedLogin
+	RecordFailedLogin(ctx context.Context, userId string) (*models.User, error)
 }
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	pb "goAuth/proto/gen"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListUsers lets admins browse registered users
+func (s *Server) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	switch req.GetProvider() {
+	case "", "password", "google", "passkey":
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Provider must be password, google or passkey")
+	}
+
+	switch req.GetSortOrder() {
+	case "", "asc", "desc":
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Sort order must be asc or desc")
+	}
+
+	pageSize, err := pageParams(req.GetPageSize(), req.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	filter := models.UserFilter{
+		Role:        req.GetRole(),
+		Status:      req.GetStatus(),
+		EmailDomain: req.GetEmailDomain(),
+		Provider:    req.GetProvider(),
+		OrgId:       utils.CallerOrganization(ctx),
+	}
+
+	users, nextPageToken, err := mongodb.ListUsers(ctx, filter, req.GetSortOrder() == "asc", pageSize, req.GetPageToken())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &pb.ListUsersResponse{
+		NextPageToken: nextPageToken,
+	}
+	for i := range users {
+		response.Users = append(response.Users, publicUser(&users[i]))
+	}
+
+	return response, nil
+}
+
+// GetUser lets admins look up a single user. Soft deleted users are only found by id, their username and email
+// may already belong to someone else
+func (s *Server) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	var user *models.User
+	var err error
+	switch {
+	case req.GetId() != "" && req.GetUsername() == "" && req.GetEmail() == "":
+		user, err = mongodb.GetUserById(ctx, req.GetId())
+	case req.GetUsername() != "" && req.GetId() == "" && req.GetEmail() == "":
+		user, err = mongodb.GetUserByUsername(ctx, req.GetUsername())
+	case req.GetEmail() != "" && req.GetId() == "" && req.GetUsername() == "":
+		user, err = mongodb.GetUserByEmail(ctx, req.GetEmail())
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Set exactly one of id, username or email")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user == nil || !visibleToCaller(ctx, user) {
+		return nil, status.Error(codes.NotFound, "User not found")
+	}
+	utils.SetAuditTarget(ctx, user.Id)
+
+	err = authorizeUserAccess(ctx, utils.PermUsersRead, user, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GetUserResponse{
+		User: publicUser(user),
+	}, nil
+}
+
+// DeleteUser lets admins remove an account. The user is only marked as deleted and their tokens revoked;
+// the record is purged after utils.UserPurgeAfter
+func (s *Server) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	user, err := manageableUser(ctx, utils.PermUsersDelete, req.GetId(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := mongodb.SoftDeleteUser(ctx, user.Id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !deleted {
+		return nil, status.Error(codes.FailedPrecondition, "User is already deleted")
+	}
+
+	if slices.Contains(user.Roles, utils.RoleSuperAdmin) {
+		err = undoIfLastSuperAdmin(ctx, func() error {
+			return mongodb.RestoreAccountStatus(ctx, user.Id, user.AccountStatus())
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &pb.DeleteUserResponse{
+		Status:  true,
+		PurgeAt: time.Now().Add(utils.UserPurgeAfter()).Unix(),
+	}, nil
+}
@@ -0,0 +1,59 @@
+package interceptors
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
+	"goAuth/pkg/utils"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// NewAuditInterceptor records an audit event for every call through the writer. It must run first in the chain
+// so that calls rejected by authentication or rate limiting are recorded too
+func NewAuditInterceptor(writer *mongodb.AuditWriter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, auditInfo := utils.WithAuditInfo(ctx)
+
+		resp, err := handler(ctx, req)
+
+		actorId, actorUsername, targetId, details := auditInfo.Snapshot()
+
+		// Fall back to the identifiers in the request itself, e.g. the username of a failed login
+		// or the user id an admin acted upon. Secrets such as passwords are never copied
+		if actorUsername == "" {
+			if r, ok := req.(interface{ GetUsername() string }); ok {
+				actorUsername = r.GetUsername()
+			}
+		}
+		if targetId == "" {
+			if r, ok := req.(interface{ GetId() string }); ok {
+				targetId = r.GetId()
+			}
+		}
+
+		event := &models.AuditEvent{
+			EventType:     path.Base(info.FullMethod),
+			ActorId:       actorId,
+			ActorUsername: actorUsername,
+			TargetId:      targetId,
+			IP:            utils.ClientIP(ctx),
+			UserAgent:     utils.UserAgent(ctx),
+			Outcome:       "success",
+			Code:          status.Code(err).String(),
+			Details:       details,
+			Timestamp:     time.Now().UTC(),
+		}
+		if err != nil {
+			event.Outcome = "failure"
+		}
+
+		// Written in the background, so neither the caller nor a cancelled request context holds it up
+		writer.Enqueue(event)
+
+		return resp, err
+	}
+}
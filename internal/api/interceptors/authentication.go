@@ -3,111 +3,155 @@ package interceptors
 import (
 	"context"
 	"fmt"
+	"goAuth/internal/models"
+	"goAuth/internal/repositories/mongodb"
 	"goAuth/pkg/utils"
 	"os"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
-func AuthenticationInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+// NewAuthenticationInterceptor authenticates callers of every method the policy does not declare public,
+// then enforces the roles and permissions the policy requires. Methods without a policy are refused
+func NewAuthenticationInterceptor(policy *RPCPolicy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		methodPolicy, ok := policy.Methods[info.FullMethod]
+		if !ok {
+			return nil, status.Error(codes.PermissionDenied, "No access policy for this method")
+		}
 
-	// Skip some rpcs
-	skipMethods := map[string]bool{
-		"/main.AuthService/Register":    true,
-		"/main.AuthService/Login":       true,
-		"/main.AuthService/GoogleLogin": true,
-	}
+		if methodPolicy.Access == AccessPublic {
+			return handler(ctx, req)
+		}
+
+		ctx, err := authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		err = methodPolicy.checkScope(ctx)
+		if err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+
+		err = methodPolicy.authorize(ctx)
+		if err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
 
-	if skipMethods[info.FullMethod] {
 		return handler(ctx, req)
 	}
+}
 
+// authenticate validates the bearer token and returns a context carrying the caller's identity
+func authenticate(ctx context.Context) (context.Context, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return nil, status.Error(codes.Unauthenticated, "Metadata unavailable")
 	}
 
-	authHeader, ok := md["authorization"]
+	_, ok = md["authorization"]
 	if !ok {
 		return nil, status.Error(codes.Unauthenticated, "Authorization token unavailable")
 	}
 
+	claims, err := AuthenticateToken(ctx, BearerToken(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Authentication successful for user: %s (roles: %s)\n", claims.Username, strings.Join(claims.Roles, ", "))
+	utils.SetAuditActor(ctx, claims.UserId, claims.Username)
+
+	newCtx := context.WithValue(ctx, utils.ContextKey("roles"), claims.Roles)
+	newCtx = context.WithValue(newCtx, utils.ContextKey("userId"), claims.UserId)
+	newCtx = context.WithValue(newCtx, utils.ContextKey("username"), claims.Username)
+	newCtx = context.WithValue(newCtx, utils.ContextKey("expiresAt"), claims.ExpiresAt)
+	if claims.IssuedAt > 0 {
+		newCtx = context.WithValue(newCtx, utils.ContextKey("issuedAt"), claims.IssuedAt)
+	}
+	if claims.OrgId != "" {
+		newCtx = context.WithValue(newCtx, utils.ContextKey("orgId"), claims.OrgId)
+		utils.AddAuditDetail(ctx, "org_id", claims.OrgId)
+	}
+
+	return newCtx, nil
+}
+
+// BearerToken returns the access token sent in the authorization metadata, or "" if there is none. Public rpcs
+// that behave differently for signed in callers pass it to AuthenticateToken themselves
+func BearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	authHeader := md["authorization"]
+	if len(authHeader) == 0 {
+		return ""
+	}
+
 	tokenStr := strings.TrimPrefix(authHeader[0], "Bearer ")
-	tokenStr = strings.TrimSpace(tokenStr)
+	return strings.TrimSpace(tokenStr)
+}
 
+// AuthenticateToken runs every check an access token must pass: logout blacklist, signature and expiry, account
+// status, revocation and organisation membership. It is shared by authenticated calls and Authorize, and returns
+// gRPC status errors
+func AuthenticateToken(ctx context.Context, tokenStr string) (*utils.AccessClaims, error) {
 	// Check if token is blacklisted (user has logged out)
 	isBlacklisted := utils.JwtStore.IsBlacklisted(tokenStr)
 	if isBlacklisted {
 		return nil, status.Error(codes.Unauthenticated, "Token has been revoked (logged out)")
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
+	if os.Getenv("JWT_SECRET") == "" {
 		fmt.Println("ERROR: JWT_SECRET is not set")
 		return nil, status.Error(codes.Internal, "JWT_SECRET not configured")
 	}
 
-	parsedToken, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			fmt.Printf("ERROR: Invalid signing method: %v\n", token.Method)
-			return nil, status.Error(codes.Unauthenticated, "Invalid signing method")
-		}
-		return []byte(jwtSecret), nil
-	})
-
+	claims, err := utils.ParseAccessToken(tokenStr)
 	if err != nil {
-		fmt.Printf("ERROR: Token parsing failed: %v\n", err)
-		return nil, status.Error(codes.Unauthenticated, fmt.Sprintf("Token parsing failed: %v", err))
+		fmt.Printf("ERROR: %v\n", err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
 	}
 
-	if !parsedToken.Valid {
-		fmt.Println("ERROR: Token is not valid")
-		return nil, status.Error(codes.Unauthenticated, "Token is not valid")
+	// A valid signature is not enough: suspended users and tokens revoked after they were issued are rejected
+	user, err := mongodb.GetUserAccessState(ctx, claims.UserId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error checking account status")
 	}
-
-	claims, ok := parsedToken.Claims.(jwt.MapClaims)
-	if !ok {
-		fmt.Println("ERROR: Cannot parse claims")
-		return nil, status.Error(codes.Unauthenticated, "Cannot parse claims")
+	if user == nil {
+		return nil, status.Error(codes.Unauthenticated, "Account no longer exists")
 	}
-
-	role, ok := claims["role"].(string)
-	if !ok {
-		fmt.Printf("ERROR: Role claim missing or invalid. Claims: %v\n", claims)
-		return nil, status.Error(codes.Unauthenticated, "Role claim missing")
+	err = AccountStatusError(user)
+	if err != nil {
+		return nil, err
 	}
-
-	userId, ok := claims["uid"].(string)
-	if !ok {
-		fmt.Printf("ERROR: UID claim missing or invalid. Claims: %v\n", claims)
-		return nil, status.Error(codes.Unauthenticated, "UID claim missing")
+	if user.TokenRevoked(claims.IssuedAt) {
+		return nil, status.Error(codes.Unauthenticated, "Token has been revoked")
 	}
-
-	username, ok := claims["user"].(string)
-	if !ok {
-		fmt.Printf("ERROR: User claim missing or invalid. Claims: %v\n", claims)
-		return nil, status.Error(codes.Unauthenticated, "User claim missing")
+	// Tokens scoped to an organisation stop working as soon as the user leaves it
+	if claims.OrgId != "" && user.Membership(claims.OrgId) == nil {
+		return nil, status.Error(codes.Unauthenticated, "No longer a member of the organization")
 	}
 
-	expiresAtF64, ok := claims["exp"].(float64)
-	if !ok {
-		fmt.Printf("ERROR: Expiry claim missing or invalid. Claims: %v\n", claims)
-		return nil, status.Error(codes.Unauthenticated, "Expiry claim missing")
-	}
-	expiresAtInt := int64(expiresAtF64)
-
-	fmt.Printf("Authentication successful for user: %s (role: %s)\n", username, role)
-
-	newCtx := context.WithValue(ctx, utils.ContextKey("role"), role)
-	newCtx = context.WithValue(newCtx, utils.ContextKey("userId"), userId)
-	newCtx = context.WithValue(newCtx, utils.ContextKey("username"), username)
-	newCtx = context.WithValue(newCtx, utils.ContextKey("expiresAt"), expiresAtInt)
+	return claims, nil
+}
 
-	return handler(newCtx, req)
+// AccountStatusError returns the error for users that may not log in or call anything, or nil for active users
+func AccountStatusError(user *models.User) error {
+	switch user.AccountStatus() {
+	case models.UserStatusSuspended:
+		return status.Error(codes.PermissionDenied, "Account is suspended")
+	case models.UserStatusPendingVerification:
+		return status.Error(codes.PermissionDenied, "Account is pending verification")
+	case models.UserStatusDeleted:
+		return status.Error(codes.PermissionDenied, "Account has been deleted")
+	}
+	return nil
 }
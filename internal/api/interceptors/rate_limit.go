@@ -0,0 +1,52 @@
+package interceptors
+
+import (
+	"context"
+	"goAuth/pkg/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// NewRateLimitInterceptor enforces the token bucket rule configured for each full method name ("*" for all others).
+// It runs after AuthenticationInterceptor so buckets can be keyed by the authenticated user
+func NewRateLimitInterceptor(limiter utils.RateLimiter, rules map[string]utils.RateLimitRule) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		rule, ok := rules[info.FullMethod]
+		if !ok {
+			rule, ok = rules["*"]
+			if !ok {
+				return handler(ctx, req)
+			}
+		}
+
+		ip := utils.ClientIP(ctx)
+		userId, _ := ctx.Value(utils.ContextKey("userId")).(string)
+
+		var subject string
+		switch rule.KeyBy {
+		case "user":
+			subject = "user:" + userId
+			if userId == "" {
+				subject = "ip:" + ip
+			}
+		case "ip+user":
+			subject = "ip:" + ip + "|user:" + userId
+		default:
+			subject = "ip:" + ip
+		}
+
+		allowed, retryAfter, err := limiter.Allow(ctx, info.FullMethod+"|"+subject, rule)
+		if err != nil {
+			// Fail open: an unavailable rate limit store should not take authentication down with it
+			utils.ErrorHandler(err, "Rate limiter unavailable")
+			return handler(ctx, req)
+		}
+
+		if !allowed {
+			return nil, utils.RetryAfterError(codes.ResourceExhausted, "Too many requests, try again later", retryAfter)
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,159 @@
+package interceptors
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"goAuth/pkg/utils"
+	"os"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed rpc_policy.yaml
+var defaultRPCPolicy []byte
+
+const (
+	AccessPublic        = "public"
+	AccessAuthenticated = "authenticated"
+)
+
+// Scopes restrict which tokens may call a method. Methods without a scope accept both kinds
+const (
+	// Only tokens that are not scoped to an organisation
+	ScopePlatform = "platform"
+	// Only tokens scoped to an organisation, see SwitchOrganization
+	ScopeOrganization = "organization"
+)
+
+// MethodPolicy declares who may call one rpc
+type MethodPolicy struct {
+	Access      string   `yaml:"access"`
+	Roles       []string `yaml:"roles"`
+	Permissions []string `yaml:"permissions"`
+	Scope       string   `yaml:"scope"`
+}
+
+// RPCPolicy maps full method names (e.g. /main.AuthService/Login) to their policy
+type RPCPolicy struct {
+	Methods map[string]MethodPolicy `yaml:"methods"`
+}
+
+// LoadRPCPolicy reads the file named by RPC_POLICY_FILE, or the built-in rpc_policy.yaml when it is not set
+func LoadRPCPolicy() (*RPCPolicy, error) {
+	data := defaultRPCPolicy
+	if path := os.Getenv("RPC_POLICY_FILE"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, utils.ErrorHandler(err, "Error reading rpc policy")
+		}
+	}
+
+	var policy RPCPolicy
+	err := yaml.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error parsing rpc policy")
+	}
+
+	return &policy, nil
+}
+
+// Check makes sure every unary method registered on the server has a valid policy and that the policy does not
+// mention methods, roles or permissions that do not exist. Streaming methods such as server reflection are not
+// covered since the interceptor only sees unary calls
+func (policy *RPCPolicy) Check(services map[string]grpc.ServiceInfo) error {
+	var problems []string
+
+	registered := make(map[string]bool)
+	for serviceName, service := range services {
+		for _, method := range service.Methods {
+			if method.IsClientStream || method.IsServerStream {
+				continue
+			}
+			fullMethod := "/" + serviceName + "/" + method.Name
+			registered[fullMethod] = true
+			if _, ok := policy.Methods[fullMethod]; !ok {
+				problems = append(problems, fullMethod+" has no policy")
+			}
+		}
+	}
+
+	for fullMethod, methodPolicy := range policy.Methods {
+		if !registered[fullMethod] {
+			problems = append(problems, fullMethod+" is not a registered method")
+		}
+
+		switch methodPolicy.Access {
+		case AccessPublic:
+			if len(methodPolicy.Roles) > 0 || len(methodPolicy.Permissions) > 0 {
+				problems = append(problems, fullMethod+" is public but lists roles or permissions")
+			}
+		case AccessAuthenticated:
+		default:
+			problems = append(problems, fmt.Sprintf("%s has unknown access %q", fullMethod, methodPolicy.Access))
+		}
+
+		switch methodPolicy.Scope {
+		case "":
+		case ScopePlatform, ScopeOrganization:
+			if methodPolicy.Access == AccessPublic {
+				problems = append(problems, fullMethod+" is public but has a scope")
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s has unknown scope %q", fullMethod, methodPolicy.Scope))
+		}
+
+		for _, role := range methodPolicy.Roles {
+			if !utils.RoleRegistry.Exists(role) {
+				problems = append(problems, fmt.Sprintf("%s requires unknown role %q", fullMethod, role))
+			}
+		}
+		for _, permission := range methodPolicy.Permissions {
+			if !utils.IsKnownPermission(permission) {
+				problems = append(problems, fmt.Sprintf("%s requires unknown permission %q", fullMethod, permission))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid rpc policy:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// checkScope checks that the caller's token is scoped the way the method requires
+func (policy MethodPolicy) checkScope(ctx context.Context) error {
+	orgId := utils.CallerOrganization(ctx)
+	switch {
+	case policy.Scope == ScopePlatform && orgId != "":
+		return errors.New("this method is not available with a token scoped to an organization")
+	case policy.Scope == ScopeOrganization && orgId == "":
+		return errors.New("this method requires a token scoped to an organization, see SwitchOrganization")
+	}
+	return nil
+}
+
+// authorize checks the roles and permissions a method requires against an authenticated caller
+func (policy MethodPolicy) authorize(ctx context.Context) error {
+	if len(policy.Roles) > 0 {
+		err := utils.AuthorizeUser(ctx, policy.Roles...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, permission := range policy.Permissions {
+		err := utils.RequirePermission(ctx, permission)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
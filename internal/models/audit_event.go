@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// AuditEvent is an append-only record of one AuthService call. Events form a hash chain:
+// Hash is an HMAC over the event's contents and PrevHash, the Hash of the event with the previous Seq
+type AuditEvent struct {
+	Id            string            `bson:"_id,omitempty"`
+	Seq           int64             `bson:"seq"`
+	PrevHash      string            `bson:"prev_hash"`
+	Hash          string            `bson:"hash"`
+	EventType     string            `bson:"event_type"`
+	ActorId       string            `bson:"actor_id,omitempty"`
+	ActorUsername string            `bson:"actor_username,omitempty"`
+	TargetId      string            `bson:"target_id,omitempty"`
+	IP            string            `bson:"ip,omitempty"`
+	UserAgent     string            `bson:"user_agent,omitempty"`
+	Outcome       string            `bson:"outcome"`
+	Code          string            `bson:"code"`
+	Details       map[string]string `bson:"details,omitempty"`
+	Timestamp     time.Time         `bson:"timestamp"`
+}
+
+// AuditFilter narrows down QueryAuditEvents, empty fields match everything
+type AuditFilter struct {
+	EventType string
+	ActorId   string
+	TargetId  string
+	Outcome   string
+	Since     time.Time
+	Until     time.Time
+
+	// Calls made with a token scoped to this organisation, which is recorded in the org_id detail
+	OrgId string
+}
+
+// AuditChainReport is the result of walking the audit hash chain. Reason is empty when every link is intact
+type AuditChainReport struct {
+	Checked   int64
+	HeadSeq   int64
+	HeadHash  string
+	BrokenSeq int64
+	BrokenId  string
+	Reason    string
+}
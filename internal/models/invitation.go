@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+const (
+	InvitationStatusPending  = "pending"
+	InvitationStatusAccepted = "accepted"
+	InvitationStatusRevoked  = "revoked"
+	// Never stored: pending invitations past their expiry are reported as expired
+	InvitationStatusExpired = "expired"
+)
+
+// Invitation asks someone, by email, to join an organisation with the given roles
+type Invitation struct {
+	Id         string    `bson:"_id,omitempty"`
+	OrgId      string    `bson:"org_id"`
+	Email      string    `bson:"email"`
+	Roles      []string  `bson:"roles"`
+	Status     string    `bson:"status"`
+	InvitedBy  string    `bson:"invited_by"`
+	CreatedAt  time.Time `bson:"created_at"`
+	ExpiresAt  time.Time `bson:"expires_at"`
+	AcceptedBy string    `bson:"accepted_by,omitempty"`
+	AcceptedAt time.Time `bson:"accepted_at,omitempty"`
+	RevokedAt  time.Time `bson:"revoked_at,omitempty"`
+}
+
+// CurrentStatus returns the invitation's status, reporting expired pending invitations as expired
+func (i *Invitation) CurrentStatus() string {
+	if i.Status == InvitationStatusPending && !time.Now().Before(i.ExpiresAt) {
+		return InvitationStatusExpired
+	}
+	return i.Status
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// KnownDevice is a device and network a user has successfully logged in from
+type KnownDevice struct {
+	Fingerprint string    `bson:"fingerprint"`
+	IPPrefix    string    `bson:"ip_prefix"`
+	UserAgent   string    `bson:"user_agent,omitempty"`
+	FirstSeenAt time.Time `bson:"first_seen_at"`
+	LastSeenAt  time.Time `bson:"last_seen_at"`
+}
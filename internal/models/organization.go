@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Organization is a tenant. Users belong to organisations through the memberships stored on the user
+type Organization struct {
+	Id        string    `bson:"_id,omitempty"`
+	Name      string    `bson:"name"`
+	CreatedBy string    `bson:"created_by"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// Membership gives a user roles within one organisation. Tokens scoped to the organisation carry these roles
+// instead of the user's global ones
+type Membership struct {
+	OrgId    string    `bson:"org_id"`
+	Roles    []string  `bson:"roles"`
+	JoinedAt time.Time `bson:"joined_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Passkey is a WebAuthn credential registered by a user
+type Passkey struct {
+	CredentialId    []byte    `bson:"credential_id"`
+	PublicKey       []byte    `bson:"public_key"`
+	Name            string    `bson:"name,omitempty"`
+	AttestationType string    `bson:"attestation_type,omitempty"`
+	Transports      []string  `bson:"transports,omitempty"`
+	AAGUID          []byte    `bson:"aaguid,omitempty"`
+	SignCount       uint32    `bson:"sign_count"`
+	UserVerified    bool      `bson:"user_verified"`
+	BackupEligible  bool      `bson:"backup_eligible"`
+	BackupState     bool      `bson:"backup_state"`
+	CreatedAt       time.Time `bson:"created_at"`
+	LastUsedAt      time.Time `bson:"last_used_at,omitempty"`
+}
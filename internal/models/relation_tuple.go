@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// RelationTuple is one version of a relation tuple in the relation_tuples collection. Deleting a tuple only sets
+// DeletedRev, so reads at earlier revisions still see it until the record expires
+type RelationTuple struct {
+	Namespace        string    `bson:"namespace"`
+	ObjectId         string    `bson:"object_id"`
+	Relation         string    `bson:"relation"`
+	SubjectNamespace string    `bson:"subject_namespace"`
+	SubjectId        string    `bson:"subject_id"`
+	SubjectRelation  string    `bson:"subject_relation"`
+	CreatedRev       int64     `bson:"created_rev"`
+	DeletedRev       int64     `bson:"deleted_rev"`
+	DeletedAt        time.Time `bson:"deleted_at,omitempty"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Role is a named set of permissions stored in the roles collection
+type Role struct {
+	Name        string    `bson:"_id"`
+	Description string    `bson:"description,omitempty"`
+	Permissions []string  `bson:"permissions"`
+	Inherits    []string  `bson:"inherits,omitempty"`
+	BuiltIn     bool      `bson:"built_in,omitempty"`
+	UpdatedAt   time.Time `bson:"updated_at"`
+}
@@ -1,11 +1,112 @@
 package models
 
+import "time"
+
+// Account statuses. Users created before statuses were introduced have none and count as active
+const (
+	UserStatusActive              = "active"
+	UserStatusSuspended           = "suspended"
+	UserStatusPendingVerification = "pending_verification"
+	UserStatusDeleted             = "deleted"
+)
+
 type User struct {
 	Id       string `protobuf:"id,omitempty" bson:"_id,omitempty"`
 	Username string `protobuf:"username,omitempty" bson:"username,omitempty"`
 	Email    string `protobuf:"email,omitempty" bson:"email,omitempty"`
 	Password string `protobuf:"password,omitempty" bson:"password,omitempty"`
-	Role     string `protobuf:"role,omitempty" bson:"role,omitempty"`
 	GoogleId string `protobuf:"google_id,omitempty" bson:"google_id,omitempty"`
 	Picture  string `protobuf:"picture,omitempty" bson:"picture,omitempty"`
+	Status   string `protobuf:"status,omitempty" bson:"status,omitempty"`
+
+	// Every role assigned to the user. Documents from before users could have several roles stored a single
+	// role field, which mongodb.MigrateUserRoles moves here on startup
+	Roles []string `protobuf:"roles,omitempty" bson:"roles,omitempty"`
+
+	// Access tokens issued at or before this time are rejected, e.g. after a suspension
+	TokensRevokedAt time.Time `protobuf:"tokens_revoked_at,omitempty" bson:"tokens_revoked_at,omitempty"`
+
+	// When the user was soft deleted; the record is purged once utils.UserPurgeAfter has passed
+	DeletedAt time.Time `protobuf:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+
+	// Previous password hashes, most recent first, used to block password reuse
+	PasswordHistory []string `protobuf:"password_history,omitempty" bson:"password_history,omitempty"`
+
+	// TOTP two-factor authentication. The pending secret is only promoted once the user confirms a code
+	TotpEnabled       bool   `protobuf:"totp_enabled,omitempty" bson:"totp_enabled,omitempty"`
+	TotpSecret        string `protobuf:"totp_secret,omitempty" bson:"totp_secret,omitempty"`
+	TotpPendingSecret string `protobuf:"totp_pending_secret,omitempty" bson:"totp_pending_secret,omitempty"`
+	TotpLastStep      int64  `protobuf:"totp_last_step,omitempty" bson:"totp_last_step,omitempty"`
+
+	// Hashes of the unused single-use MFA recovery codes
+	RecoveryCodes []string `protobuf:"recovery_codes,omitempty" bson:"recovery_codes,omitempty"`
+
+	// WebAuthn credentials used for passwordless sign-in
+	Passkeys []Passkey `protobuf:"passkeys,omitempty" bson:"passkeys,omitempty"`
+
+	// Pending emailed login code (also embedded in magic links)
+	LoginCodeHash      string    `protobuf:"login_code_hash,omitempty" bson:"login_code_hash,omitempty"`
+	LoginCodeExpiresAt time.Time `protobuf:"login_code_expires_at,omitempty" bson:"login_code_expires_at,omitempty"`
+	LoginCodeAttempts  int       `protobuf:"login_code_attempts,omitempty" bson:"login_code_attempts,omitempty"`
+
+	// Login codes sent since the start of the current limit window
+	LoginCodesSent       int       `protobuf:"login_codes_sent,omitempty" bson:"login_codes_sent,omitempty"`
+	LoginCodeWindowStart time.Time `protobuf:"login_code_window_start,omitempty" bson:"login_code_window_start,omitempty"`
+
+	// Failed password logins since the last successful one, and when the next attempt is allowed
+	FailedLoginAttempts int       `protobuf:"failed_login_attempts,omitempty" bson:"failed_login_attempts,omitempty"`
+	LastFailedLoginAt   time.Time `protobuf:"last_failed_login_at,omitempty" bson:"last_failed_login_at,omitempty"`
+	LoginRetryAt        time.Time `protobuf:"login_retry_at,omitempty" bson:"login_retry_at,omitempty"`
+
+	// Devices and networks of recent successful logins, used to flag unfamiliar logins
+	KnownDevices []KnownDevice `protobuf:"known_devices,omitempty" bson:"known_devices,omitempty"`
+
+	// Organisations the user belongs to and their roles in each
+	Memberships []Membership `protobuf:"memberships,omitempty" bson:"memberships,omitempty"`
+
+	// Organisation whose invitation created the account. Only its admins can suspend, delete or unlock the
+	// account from within an organisation
+	OwnerOrgId string `protobuf:"owner_org_id,omitempty" bson:"owner_org_id,omitempty"`
+}
+
+// UserFilter narrows down ListUsers, empty fields match everyone. Provider is "password", "google" or "passkey"
+type UserFilter struct {
+	Role        string
+	Status      string
+	EmailDomain string
+	Provider    string
+	// Only members of this organisation; Role then matches their role in it
+	OrgId string
+}
+
+// AccountStatus returns the user's status, treating a missing status as active
+func (u *User) AccountStatus() string {
+	if u.Status == "" {
+		return UserStatusActive
+	}
+	return u.Status
+}
+
+// TokenRevoked reports whether an access token issued at the given unix time was revoked, e.g. by a suspension
+func (u *User) TokenRevoked(issuedAt int64) bool {
+	return !u.TokensRevokedAt.IsZero() && issuedAt <= u.TokensRevokedAt.Unix()
+}
+
+// Membership returns the user's membership of an organisation, or nil if they are not a member
+func (u *User) Membership(orgId string) *Membership {
+	for i := range u.Memberships {
+		if u.Memberships[i].OrgId == orgId {
+			return &u.Memberships[i]
+		}
+	}
+	return nil
+}
+
+// OrganizationIds lists the organisations the user belongs to
+func (u *User) OrganizationIds() []string {
+	orgIds := make([]string, 0, len(u.Memberships))
+	for _, membership := range u.Memberships {
+		orgIds = append(orgIds, membership.OrgId)
+	}
+	return orgIds
 }
@@ -0,0 +1,176 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SuspendUser marks a user as suspended and revokes every access token issued so far.
+// It returns false if no active (or pending) user with this id exists
+func SuspendUser(ctx context.Context, userId string) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{
+		"_id":    objId,
+		"status": bson.M{"$nin": bson.A{models.UserStatusSuspended, models.UserStatusDeleted}},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":            models.UserStatusSuspended,
+			"tokens_revoked_at": time.Now(),
+		},
+	}
+
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error suspending user")
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+// ReactivateUser makes a suspended or pending_verification user active. Tokens revoked by the suspension stay
+// revoked. It returns false if no suspended or pending user with this id exists
+func ReactivateUser(ctx context.Context, userId string) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{"_id": objId, "status": bson.M{"$in": bson.A{models.UserStatusSuspended, models.UserStatusPendingVerification}}}
+	update := bson.M{"$set": bson.M{"status": models.UserStatusActive}}
+
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error reactivating user")
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+// SoftDeleteUser marks a user as deleted and revokes every access token issued so far. The record is kept
+// until PurgeDeletedUsers removes it. It returns false if no user with this id exists or it is already deleted
+func SoftDeleteUser(ctx context.Context, userId string) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	now := time.Now()
+	filter := bson.M{"_id": objId, "status": bson.M{"$ne": models.UserStatusDeleted}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":            models.UserStatusDeleted,
+			"deleted_at":        now,
+			"tokens_revoked_at": now,
+		},
+	}
+
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error deleting user")
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+// RestoreAccountStatus puts back the status a user had before SuspendUser or SoftDeleteUser, to undo a change
+// that turned out not to be allowed. Tokens revoked by the change stay revoked
+func RestoreAccountStatus(ctx context.Context, userId, previousStatus string) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"status": previousStatus},
+		"$unset": bson.M{"deleted_at": ""},
+	}
+
+	_, err = client.Database("auth").Collection("users").UpdateOne(ctx, bson.M{"_id": objId}, update)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error restoring account status")
+	}
+
+	return nil
+}
+
+// PurgeDeletedUsers removes users that were soft deleted before the given time and returns how many were removed
+func PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	filter := bson.M{
+		"status":     models.UserStatusDeleted,
+		"deleted_at": bson.M{"$lt": deletedBefore},
+	}
+
+	res, err := client.Database("auth").Collection("users").DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error purging deleted users")
+	}
+
+	return res.DeletedCount, nil
+}
+
+// GetUserAccessState returns only what authenticating a token needs to know about its user: status, token
+// revocation and memberships, or nil if the user does not exist. It uses the shared client, since it runs on every call
+func GetUserAccessState(ctx context.Context, userId string) (*models.User, error) {
+	client, err := SharedMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return nil, nil
+	}
+
+	projection := bson.M{"status": 1, "tokens_revoked_at": 1, "memberships": 1}
+	var user models.User
+	err = client.Database("auth").Collection("users").FindOne(ctx, bson.M{"_id": objId}, options.FindOne().SetProjection(projection)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading account status")
+	}
+	return &user, nil
+}
@@ -0,0 +1,143 @@
+package mongodb
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureAuditIndexes creates the unique seq index that keeps the audit chain linear when several replicas append.
+// Every event must have a seq, so the index also rejects events inserted outside the chain
+func EnsureAuditIndexes(ctx context.Context) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	_, err = client.Database("auth").Collection("audit_events").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"seq": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return utils.ErrorHandler(err, "Error creating audit index")
+	}
+
+	return nil
+}
+
+// VerifyAuditChain walks the audit chain from the first event and stops at the first broken link: an event without
+// a seq, a gap in seq, a prev_hash that does not match the previous event, or a hash that does not match the
+// event's contents. Removing events from the end cannot be detected from the chain alone, so callers should keep
+// HeadSeq and HeadHash
+func VerifyAuditChain(ctx context.Context) (*models.AuditChainReport, error) {
+	key, err := utils.AuditChainKey()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading audit chain key")
+	}
+
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	opts := options.Find().SetSort(bson.M{"seq": 1})
+	cursor, err := client.Database("auth").Collection("audit_events").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading audit events")
+	}
+	defer cursor.Close(ctx)
+
+	report := &models.AuditChainReport{}
+	for cursor.Next(ctx) {
+		var event models.AuditEvent
+		err = cursor.Decode(&event)
+		if err != nil {
+			return nil, utils.ErrorHandler(err, "Error decoding audit event")
+		}
+		report.Checked++
+
+		reason := ""
+		switch {
+		case event.Seq == 0:
+			// Sorted first, so an event without a seq is reported before the chain is walked
+			reason = "event has no seq"
+		case event.Seq != report.HeadSeq+1:
+			reason = fmt.Sprintf("expected seq %d, found %d", report.HeadSeq+1, event.Seq)
+		case event.PrevHash != report.HeadHash:
+			reason = "prev_hash does not match the previous event"
+		case !hmac.Equal([]byte(event.Hash), []byte(auditEventHash(&event, key))):
+			reason = "hash does not match the event contents"
+		}
+		if reason != "" {
+			report.BrokenSeq = event.Seq
+			report.BrokenId = event.Id
+			report.Reason = reason
+			return report, nil
+		}
+
+		report.HeadSeq = event.Seq
+		report.HeadHash = event.Hash
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading audit events")
+	}
+
+	return report, nil
+}
+
+// auditEventHash is the hex HMAC-SHA256, keyed with AuditChainKey, of the event's contents and the previous event's
+// hash. The event id is not covered since it is only assigned on insert; seq identifies the event within the chain
+func auditEventHash(event *models.AuditEvent, key []byte) string {
+	details := event.Details
+	if len(details) == 0 {
+		// Empty details are not stored, so nil and empty must hash the same
+		details = nil
+	}
+
+	// Struct fields are encoded in declaration order and map keys sorted, which keeps the encoding stable
+	contents, _ := json.Marshal(struct {
+		Seq           int64             `json:"seq"`
+		PrevHash      string            `json:"prev_hash"`
+		EventType     string            `json:"event_type"`
+		ActorId       string            `json:"actor_id"`
+		ActorUsername string            `json:"actor_username"`
+		TargetId      string            `json:"target_id"`
+		IP            string            `json:"ip"`
+		UserAgent     string            `json:"user_agent"`
+		Outcome       string            `json:"outcome"`
+		Code          string            `json:"code"`
+		Details       map[string]string `json:"details"`
+		Timestamp     string            `json:"timestamp"`
+	}{
+		Seq:           event.Seq,
+		PrevHash:      event.PrevHash,
+		EventType:     event.EventType,
+		ActorId:       event.ActorId,
+		ActorUsername: event.ActorUsername,
+		TargetId:      event.TargetId,
+		IP:            event.IP,
+		UserAgent:     event.UserAgent,
+		Outcome:       event.Outcome,
+		Code:          event.Code,
+		Details:       details,
+		Timestamp:     event.Timestamp.UTC().Format(time.RFC3339Nano),
+	})
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(contents)
+	return hex.EncodeToString(mac.Sum(nil))
+}
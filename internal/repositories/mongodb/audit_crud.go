@@ -0,0 +1,226 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// auditAppendRetries bounds how often an append is retried after losing a race for the next seq to another replica
+const auditAppendRetries = 10
+
+// auditQueueSize is how many events may wait for the audit writer before requests have to wait for it
+const auditQueueSize = 1024
+
+// AuditWriter appends audit events to the hash chain from a single goroutine, so requests only hand their event
+// over and never wait on each other. Replicas can still race for the next seq: the unique seq index rejects the
+// loser, which re-reads the chain head and retries. Like MongoRateLimiter it keeps one client open
+type AuditWriter struct {
+	queue      chan *models.AuditEvent
+	collection *mongo.Collection
+	key        []byte
+
+	// The last event this writer appended or read, so the head is only re-read after losing a race
+	headSeq  int64
+	headHash string
+	headRead bool
+}
+
+func NewAuditWriter() (*AuditWriter, error) {
+	key, err := utils.AuditChainKey()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading audit chain key")
+	}
+
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+
+	return &AuditWriter{
+		queue:      make(chan *models.AuditEvent, auditQueueSize),
+		collection: client.Database("auth").Collection("audit_events"),
+		key:        key,
+	}, nil
+}
+
+// Enqueue hands an event to the writer. It only blocks while the queue is full, so a slow database slows
+// requests down rather than losing events
+func (writer *AuditWriter) Enqueue(event *models.AuditEvent) {
+	writer.queue <- event
+}
+
+// Run appends queued events in order. Failed appends are logged, the call they describe has already been handled
+func (writer *AuditWriter) Run() {
+	ctx := context.Background()
+	for event := range writer.queue {
+		err := writer.append(ctx, event)
+		if err != nil {
+			utils.ErrorHandler(err, "Error writing audit event")
+		}
+	}
+}
+
+// append links an event to the end of the audit hash chain. Audit events are never updated or deleted
+func (writer *AuditWriter) append(ctx context.Context, event *models.AuditEvent) error {
+	// MongoDB stores milliseconds, the hash must cover exactly what is read back
+	event.Timestamp = event.Timestamp.Truncate(time.Millisecond)
+
+	for attempt := 0; attempt < auditAppendRetries; attempt++ {
+		if !writer.headRead {
+			err := writer.readHead(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		event.Seq = writer.headSeq + 1
+		event.PrevHash = writer.headHash
+		event.Hash = auditEventHash(event, writer.key)
+
+		res, err := writer.collection.InsertOne(ctx, event)
+		if mongo.IsDuplicateKeyError(err) {
+			// Another replica appended first, link to its event instead
+			writer.headRead = false
+			continue
+		}
+		if err != nil {
+			writer.headRead = false
+			return utils.ErrorHandler(err, "Error inserting audit event")
+		}
+
+		objectId, ok := res.InsertedID.(primitive.ObjectID)
+		if ok {
+			event.Id = objectId.Hex()
+		}
+		writer.headSeq, writer.headHash = event.Seq, event.Hash
+		return nil
+	}
+
+	return utils.ErrorHandler(errors.New("too many concurrent appends"), "Error inserting audit event")
+}
+
+func (writer *AuditWriter) readHead(ctx context.Context) error {
+	var head models.AuditEvent
+	opts := options.FindOne().SetSort(bson.M{"seq": -1})
+	err := writer.collection.FindOne(ctx, bson.M{}, opts).Decode(&head)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return utils.ErrorHandler(err, "Error reading audit chain head")
+	}
+
+	writer.headSeq, writer.headHash, writer.headRead = head.Seq, head.Hash, true
+	return nil
+}
+
+// QueryAuditEvents returns matching events, newest first. pageToken is the id of the last event of the
+// previous page; the returned token is empty when there are no more events
+func QueryAuditEvents(ctx context.Context, filter models.AuditFilter, pageSize int, pageToken string) ([]models.AuditEvent, string, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, "", utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	query := bson.M{}
+	if filter.EventType != "" {
+		query["event_type"] = filter.EventType
+	}
+	if filter.ActorId != "" {
+		query["actor_id"] = filter.ActorId
+	}
+	if filter.TargetId != "" {
+		query["target_id"] = filter.TargetId
+	}
+	if filter.Outcome != "" {
+		query["outcome"] = filter.Outcome
+	}
+	if filter.OrgId != "" {
+		query["details.org_id"] = filter.OrgId
+	}
+
+	timestamp := bson.M{}
+	if !filter.Since.IsZero() {
+		timestamp["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		timestamp["$lt"] = filter.Until
+	}
+	if len(timestamp) > 0 {
+		query["timestamp"] = timestamp
+	}
+
+	if pageToken != "" {
+		lastId, err := primitive.ObjectIDFromHex(pageToken)
+		if err != nil {
+			return nil, "", utils.ErrorHandler(err, "Invalid page token")
+		}
+		query["_id"] = bson.M{"$lt": lastId}
+	}
+
+	// Fetch one extra event to know whether another page exists
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(pageSize + 1))
+	cursor, err := client.Database("auth").Collection("audit_events").Find(ctx, query, opts)
+	if err != nil {
+		return nil, "", utils.ErrorHandler(err, "Error querying audit events")
+	}
+	defer cursor.Close(ctx)
+
+	var events []models.AuditEvent
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		return nil, "", utils.ErrorHandler(err, "Error reading audit events")
+	}
+
+	nextPageToken := ""
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		nextPageToken = events[pageSize-1].Id
+	}
+
+	return events, nextPageToken, nil
+}
+
+// AuditEventsForUser returns the most recent limit events performed by or on a user, oldest first, and whether
+// older events were left out
+func AuditEventsForUser(ctx context.Context, userId string, limit int) ([]models.AuditEvent, bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	query := bson.M{"$or": bson.A{
+		bson.M{"actor_id": userId},
+		bson.M{"target_id": userId},
+	}}
+
+	// Fetch one extra event to know whether any were left out
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit + 1))
+	cursor, err := client.Database("auth").Collection("audit_events").Find(ctx, query, opts)
+	if err != nil {
+		return nil, false, utils.ErrorHandler(err, "Error querying audit events")
+	}
+	defer cursor.Close(ctx)
+
+	var events []models.AuditEvent
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		return nil, false, utils.ErrorHandler(err, "Error reading audit events")
+	}
+
+	truncated := len(events) > limit
+	if truncated {
+		events = events[:limit]
+	}
+	slices.Reverse(events)
+
+	return events, truncated, nil
+}
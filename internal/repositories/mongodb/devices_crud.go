@@ -0,0 +1,56 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecordKnownDevice refreshes the last seen time of a known device/network combination,
+// or remembers a new one, keeping only the utils.KnownDevicesMax most recent
+func RecordKnownDevice(ctx context.Context, userId string, device models.KnownDevice) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	collection := client.Database("auth").Collection("users")
+
+	filter := bson.M{
+		"_id": objId,
+		"known_devices": bson.M{"$elemMatch": bson.M{
+			"fingerprint": device.Fingerprint,
+			"ip_prefix":   device.IPPrefix,
+		}},
+	}
+	update := bson.M{"$set": bson.M{"known_devices.$.last_seen_at": device.LastSeenAt}}
+
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error updating known device")
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	update = bson.M{"$push": bson.M{"known_devices": bson.M{
+		"$each":  bson.A{device},
+		"$slice": -utils.KnownDevicesMax,
+	}}}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objId}, update)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error storing known device")
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateInvitation stores a new pending invitation
+func CreateInvitation(ctx context.Context, invitation *models.Invitation) (*models.Invitation, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	invitation.Status = models.InvitationStatusPending
+
+	res, err := client.Database("auth").Collection("invitations").InsertOne(ctx, invitation)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error creating invitation")
+	}
+	objId, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, utils.ErrorHandler(err, "Invalid invitation ID")
+	}
+	invitation.Id = objId.Hex()
+
+	return invitation, nil
+}
+
+// GetInvitation returns an invitation, or nil if it does not exist
+func GetInvitation(ctx context.Context, invitationId string) (*models.Invitation, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(invitationId)
+	if err != nil {
+		return nil, nil
+	}
+
+	var invitation models.Invitation
+	err = client.Database("auth").Collection("invitations").FindOne(ctx, bson.M{"_id": objId}).Decode(&invitation)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading invitation")
+	}
+	return &invitation, nil
+}
+
+// HasPendingInvitation reports whether an email already has an unexpired invitation to an organisation
+func HasPendingInvitation(ctx context.Context, orgId, email string) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	filter := bson.M{
+		"org_id":     orgId,
+		"email":      email,
+		"status":     models.InvitationStatusPending,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+
+	count, err := client.Database("auth").Collection("invitations").CountDocuments(ctx, filter)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error reading invitations")
+	}
+	return count > 0, nil
+}
+
+// ListInvitations returns an organisation's invitations, newest first. An empty status returns all of them
+func ListInvitations(ctx context.Context, orgId, invitationStatus string) ([]models.Invitation, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	filter := bson.M{"org_id": orgId}
+	switch invitationStatus {
+	case "":
+	case models.InvitationStatusPending:
+		filter["status"] = models.InvitationStatusPending
+		filter["expires_at"] = bson.M{"$gt": time.Now()}
+	case models.InvitationStatusExpired:
+		filter["status"] = models.InvitationStatusPending
+		filter["expires_at"] = bson.M{"$lte": time.Now()}
+	default:
+		filter["status"] = invitationStatus
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := client.Database("auth").Collection("invitations").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading invitations")
+	}
+	defer cursor.Close(ctx)
+
+	var invitations []models.Invitation
+	err = cursor.All(ctx, &invitations)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading invitations")
+	}
+	return invitations, nil
+}
+
+// RevokeInvitation revokes a pending invitation of an organisation. It returns false if there is none with this id
+func RevokeInvitation(ctx context.Context, orgId, invitationId string) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(invitationId)
+	if err != nil {
+		return false, nil
+	}
+
+	filter := bson.M{"_id": objId, "org_id": orgId, "status": models.InvitationStatusPending}
+	update := bson.M{"$set": bson.M{"status": models.InvitationStatusRevoked, "revoked_at": time.Now()}}
+
+	res, err := client.Database("auth").Collection("invitations").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error revoking invitation")
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// CountInvitationsSince counts the invitations created since a time by an organisation, by a user, or by a user
+// for an organisation. Empty ids match everyone
+func CountInvitationsSince(ctx context.Context, orgId, invitedBy string, since time.Time) (int64, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	filter := bson.M{"created_at": bson.M{"$gte": since}}
+	if orgId != "" {
+		filter["org_id"] = orgId
+	}
+	if invitedBy != "" {
+		filter["invited_by"] = invitedBy
+	}
+
+	count, err := client.Database("auth").Collection("invitations").CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error reading invitations")
+	}
+	return count, nil
+}
+
+// ClaimInvitation marks a pending, unexpired invitation as accepted before anything is done with it. It returns
+// false when the invitation was revoked, has expired or was claimed by a concurrent request, so each invitation
+// is used once. SetInvitationAcceptedBy completes the claim, ReleaseInvitation undoes it
+func ClaimInvitation(ctx context.Context, invitationId string) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(invitationId)
+	if err != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	filter := bson.M{"_id": objId, "status": models.InvitationStatusPending, "expires_at": bson.M{"$gt": now}}
+	update := bson.M{"$set": bson.M{"status": models.InvitationStatusAccepted, "accepted_at": now}}
+
+	res, err := client.Database("auth").Collection("invitations").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error accepting invitation")
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// SetInvitationAcceptedBy records the user who accepted a claimed invitation
+func SetInvitationAcceptedBy(ctx context.Context, invitationId, userId string) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(invitationId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid invitation ID")
+	}
+
+	filter := bson.M{"_id": objId, "status": models.InvitationStatusAccepted}
+	_, err = client.Database("auth").Collection("invitations").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"accepted_by": userId}})
+	if err != nil {
+		return utils.ErrorHandler(err, "Error accepting invitation")
+	}
+	return nil
+}
+
+// ReleaseInvitation returns a claimed invitation to pending when accepting it failed, so it can be used again
+func ReleaseInvitation(ctx context.Context, invitationId string) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(invitationId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid invitation ID")
+	}
+
+	filter := bson.M{"_id": objId, "status": models.InvitationStatusAccepted}
+	update := bson.M{
+		"$set":   bson.M{"status": models.InvitationStatusPending},
+		"$unset": bson.M{"accepted_by": "", "accepted_at": ""},
+	}
+	_, err = client.Database("auth").Collection("invitations").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error releasing invitation")
+	}
+	return nil
+}
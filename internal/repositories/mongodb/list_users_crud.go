@@ -0,0 +1,92 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListUsers returns matching users ordered by creation time, which is the order of their ObjectIDs.
+// pageToken is the id of the last user of the previous page; the returned token is empty when there are no more users
+func ListUsers(ctx context.Context, filter models.UserFilter, ascending bool, pageSize int, pageToken string) ([]models.User, string, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, "", utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	query := bson.M{}
+	if filter.OrgId != "" {
+		membership := bson.M{"org_id": filter.OrgId}
+		if filter.Role != "" {
+			membership["roles"] = filter.Role
+		}
+		query["memberships"] = bson.M{"$elemMatch": membership}
+	} else if filter.Role != "" {
+		query["roles"] = filter.Role
+	}
+	if filter.Status == models.UserStatusActive {
+		// Users created before statuses were introduced have none
+		query["status"] = bson.M{"$in": bson.A{models.UserStatusActive, nil}}
+	} else if filter.Status != "" {
+		query["status"] = filter.Status
+	} else {
+		// Deleted users are only listed when asked for explicitly
+		query["status"] = bson.M{"$ne": models.UserStatusDeleted}
+	}
+	if filter.EmailDomain != "" {
+		query["email"] = primitive.Regex{Pattern: "@" + regexp.QuoteMeta(filter.EmailDomain) + "$", Options: "i"}
+	}
+	switch filter.Provider {
+	case "password":
+		query["password"] = bson.M{"$nin": bson.A{"", nil}}
+	case "google":
+		query["google_id"] = bson.M{"$nin": bson.A{"", nil}}
+	case "passkey":
+		query["passkeys.0"] = bson.M{"$exists": true}
+	}
+
+	sortOrder := -1
+	if ascending {
+		sortOrder = 1
+	}
+
+	if pageToken != "" {
+		lastId, err := primitive.ObjectIDFromHex(pageToken)
+		if err != nil {
+			return nil, "", utils.ErrorHandler(err, "Invalid page token")
+		}
+		if ascending {
+			query["_id"] = bson.M{"$gt": lastId}
+		} else {
+			query["_id"] = bson.M{"$lt": lastId}
+		}
+	}
+
+	// Fetch one extra user to know whether another page exists
+	opts := options.Find().SetSort(bson.M{"_id": sortOrder}).SetLimit(int64(pageSize + 1))
+	cursor, err := client.Database("auth").Collection("users").Find(ctx, query, opts)
+	if err != nil {
+		return nil, "", utils.ErrorHandler(err, "Error listing users")
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		return nil, "", utils.ErrorHandler(err, "Error reading users")
+	}
+
+	nextPageToken := ""
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		nextPageToken = users[pageSize-1].Id
+	}
+
+	return users, nextPageToken, nil
+}
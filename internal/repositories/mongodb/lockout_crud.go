@@ -0,0 +1,165 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RecordFailedLogin counts a failed password attempt and stores when the next attempt is allowed. Failures older
+// than FailedLoginsExpireAt are forgotten first, as they are for unknown usernames.
+// The updated user is returned so the caller can report the new lock state
+func RecordFailedLogin(ctx context.Context, userId string) (*models.User, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	collection := client.Database("auth").Collection("users")
+	now := time.Now()
+
+	// Increment atomically so concurrent guesses are all counted
+	var user models.User
+	forgottenBefore := now.Add(-utils.FailedLoginsExpireAfter())
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"failed_login_attempts": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{bson.M{"$ifNull": bson.A{"$last_failed_login_at", time.Time{}}}, forgottenBefore}},
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failed_login_attempts", 0}}, 1}},
+				1,
+			}},
+			"last_failed_login_at": now,
+		}}},
+	}
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": objId}, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error recording failed login")
+	}
+
+	user.LoginRetryAt = utils.LoginRetryAt(user.FailedLoginAttempts, now)
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objId}, bson.M{"$set": bson.M{"login_retry_at": user.LoginRetryAt}})
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error recording failed login")
+	}
+
+	return &user, nil
+}
+
+// ResetFailedLogins clears the failed login counter, after a successful login or when an admin unlocks the account
+func ResetFailedLogins(ctx context.Context, userId string) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	update := bson.M{
+		"$unset": bson.M{
+			"failed_login_attempts": "",
+			"last_failed_login_at":  "",
+			"login_retry_at":        "",
+		},
+	}
+
+	_, err = client.Database("auth").Collection("users").UpdateOne(ctx, bson.M{"_id": objId}, update)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error resetting failed logins")
+	}
+
+	return nil
+}
+
+// MongoUnknownUserLockouts keeps failed logins for unknown usernames in the unknown_user_lockouts collection, shared
+// by all replicas. Like RecordFailedLogin it connects per call and writes twice, so a failed login for an unknown
+// username costs the same as one for a real account
+type MongoUnknownUserLockouts struct{}
+
+func NewMongoUnknownUserLockouts(ctx context.Context) (*MongoUnknownUserLockouts, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	_, err = client.Database("auth").Collection("unknown_user_lockouts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error creating unknown user lockout index")
+	}
+
+	return &MongoUnknownUserLockouts{}, nil
+}
+
+type unknownUserLockout struct {
+	FailedAttempts int       `bson:"failed_attempts"`
+	LastFailedAt   time.Time `bson:"last_failed_at"`
+	RetryAt        time.Time `bson:"retry_at"`
+	ExpiresAt      time.Time `bson:"expires_at"`
+}
+
+func (store *MongoUnknownUserLockouts) RecordFailure(ctx context.Context, username string) (int, time.Time, bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return 0, time.Time{}, false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	collection := client.Database("auth").Collection("unknown_user_lockouts")
+	now := time.Now()
+
+	// Only counts once the back-off is over; records the TTL monitor has not removed yet start again from zero
+	filter := bson.M{"_id": username, "retry_at": bson.M{"$not": bson.M{"$gt": now}}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"failed_attempts": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$expires_at", now}}, now}},
+				bson.M{"$add": bson.A{"$failed_attempts", 1}},
+				1,
+			}},
+			"last_failed_at": now,
+		}}},
+	}
+
+	var record unknownUserLockout
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&record)
+	if mongo.IsDuplicateKeyError(err) {
+		// The username exists but is still backing off or locked
+		err = collection.FindOne(ctx, bson.M{"_id": username}).Decode(&record)
+		if err != nil {
+			return 0, time.Time{}, false, utils.ErrorHandler(err, "Error reading unknown user lockout")
+		}
+		return record.FailedAttempts, record.RetryAt, false, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, false, utils.ErrorHandler(err, "Error recording failed login")
+	}
+
+	record.RetryAt = utils.LoginRetryAt(record.FailedAttempts, now)
+	set := bson.M{"retry_at": record.RetryAt, "expires_at": utils.FailedLoginsExpireAt(now)}
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": username}, bson.M{"$set": set})
+	if err != nil {
+		return 0, time.Time{}, false, utils.ErrorHandler(err, "Error recording failed login")
+	}
+
+	return record.FailedAttempts, record.RetryAt, true, nil
+}
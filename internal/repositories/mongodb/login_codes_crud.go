@@ -0,0 +1,122 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/pkg/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SetLoginCode stores the hash of a new login code, replacing any previous one and resetting the attempt counter.
+// It returns false without storing anything once the account has been sent LoginCodeLimitPerAccount codes in the
+// current window, so requesting new codes cannot be used to get more guesses
+func SetLoginCode(ctx context.Context, userId, codeHash string, expiresAt time.Time) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	now := time.Now()
+	windowStart := now.Add(-utils.LoginCodeLimitWindow())
+
+	filter := bson.M{"_id": objId}
+	if limit := utils.LoginCodeLimitPerAccount(); limit > 0 {
+		filter["$or"] = bson.A{
+			bson.M{"login_code_window_start": bson.M{"$not": bson.M{"$gt": windowStart}}},
+			bson.M{"login_codes_sent": bson.M{"$lt": limit}},
+		}
+	}
+
+	// Counted in the same update as the check, so concurrent requests cannot all pass the limit
+	windowOpen := bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$login_code_window_start", time.Time{}}}, windowStart}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			// Argon2id hashes start with $, which a pipeline would read as a field path
+			"login_code_hash":         bson.M{"$literal": codeHash},
+			"login_code_expires_at":   expiresAt,
+			"login_code_attempts":     0,
+			"login_code_window_start": bson.M{"$cond": bson.A{windowOpen, "$login_code_window_start", now}},
+			"login_codes_sent":        bson.M{"$cond": bson.A{windowOpen, bson.M{"$add": bson.A{"$login_codes_sent", 1}}, 1}},
+		}}},
+	}
+
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error storing login code")
+	}
+
+	return res.MatchedCount == 1, nil
+}
+
+// ReserveLoginCodeAttempt counts a guess against the pending login code before it is checked. It returns false
+// when the code has been replaced, has expired or has no attempts left, so concurrent guesses cannot all pass
+// the limit on the same stale count
+func ReserveLoginCodeAttempt(ctx context.Context, userId, codeHash string) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{
+		"_id":                   objId,
+		"login_code_hash":       codeHash,
+		"login_code_expires_at": bson.M{"$gt": time.Now()},
+		"login_code_attempts":   bson.M{"$not": bson.M{"$gte": utils.LoginCodeMaxAttempts}},
+	}
+
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"login_code_attempts": 1}})
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error updating login code")
+	}
+
+	return res.MatchedCount == 1, nil
+}
+
+// ConsumeLoginCode removes the pending login code once a reserved attempt has matched it. It returns false when
+// the code was already used by a concurrent request, so each code logs in at most once
+func ConsumeLoginCode(ctx context.Context, userId, codeHash string) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{
+		"_id":             objId,
+		"login_code_hash": codeHash,
+	}
+	update := bson.M{
+		"$unset": bson.M{
+			"login_code_hash":       "",
+			"login_code_expires_at": "",
+			"login_code_attempts":   "",
+		},
+	}
+
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error updating login code")
+	}
+
+	return res.MatchedCount == 1, nil
+}
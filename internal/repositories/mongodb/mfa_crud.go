@@ -0,0 +1,169 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/pkg/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SetPendingTotpSecret stores a freshly generated TOTP secret until the user confirms it
+func SetPendingTotpSecret(ctx context.Context, userId, secret string) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	_, err = client.Database("auth").Collection("users").UpdateOne(ctx, bson.M{"_id": objId}, bson.M{"$set": bson.M{"totp_pending_secret": secret}})
+	if err != nil {
+		return utils.ErrorHandler(err, "Error storing TOTP secret")
+	}
+
+	return nil
+}
+
+// EnableTotp promotes the pending secret, records the time step of the code used to confirm it
+// and stores the hashed recovery codes
+func EnableTotp(ctx context.Context, userId, secret string, step int64, recoveryCodes []string) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"totp_enabled":   true,
+			"totp_secret":    secret,
+			"totp_last_step": step,
+			"recovery_codes": recoveryCodes,
+		},
+		"$unset": bson.M{"totp_pending_secret": ""},
+	}
+
+	_, err = client.Database("auth").Collection("users").UpdateOne(ctx, bson.M{"_id": objId}, update)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error enabling TOTP")
+	}
+
+	return nil
+}
+
+// DisableTotp removes all TOTP state from the user
+func DisableTotp(ctx context.Context, userId string) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	update := bson.M{
+		"$unset": bson.M{
+			"totp_enabled":        "",
+			"totp_secret":         "",
+			"totp_pending_secret": "",
+			"totp_last_step":      "",
+			"recovery_codes":      "",
+		},
+	}
+
+	_, err = client.Database("auth").Collection("users").UpdateOne(ctx, bson.M{"_id": objId}, update)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error disabling TOTP")
+	}
+
+	return nil
+}
+
+// ConsumeTotpStep atomically records a used TOTP time step.
+// It returns false when a code from this or a later step was already used, which blocks replays
+func ConsumeTotpStep(ctx context.Context, userId string, step int64) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{
+		"_id": objId,
+		"$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
+		},
+	}
+
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp_last_step": step}})
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error updating TOTP state")
+	}
+
+	return res.MatchedCount == 1, nil
+}
+
+// SetRecoveryCodes replaces all recovery codes of a user with new hashes
+func SetRecoveryCodes(ctx context.Context, userId string, recoveryCodes []string) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	_, err = client.Database("auth").Collection("users").UpdateOne(ctx, bson.M{"_id": objId}, bson.M{"$set": bson.M{"recovery_codes": recoveryCodes}})
+	if err != nil {
+		return utils.ErrorHandler(err, "Error storing recovery codes")
+	}
+
+	return nil
+}
+
+// ConsumeRecoveryCode removes a used recovery code hash. It returns false if the code was
+// already removed by a concurrent request, so each code works exactly once
+func ConsumeRecoveryCode(ctx context.Context, userId, recoveryCodeHash string) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{"_id": objId, "recovery_codes": recoveryCodeHash}
+	update := bson.M{"$pull": bson.M{"recovery_codes": recoveryCodeHash}}
+
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error updating recovery codes")
+	}
+
+	return res.MatchedCount == 1, nil
+}
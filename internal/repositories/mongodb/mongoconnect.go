@@ -5,6 +5,7 @@ import (
 	"goAuth/pkg/utils"
 	"log"
 	"os"
+	"sync"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -25,3 +26,26 @@ func CreateMongoClient() (*mongo.Client, error) {
 	log.Println("Connected to mongodb successfully")
 	return client, nil
 }
+
+var (
+	sharedClientMu sync.Mutex
+	sharedClient   *mongo.Client
+)
+
+// SharedMongoClient returns a long-lived client for lookups made on every request, such as the account status
+// check when authenticating, which cannot afford a new connection each time. It connects on first use
+func SharedMongoClient() (*mongo.Client, error) {
+	sharedClientMu.Lock()
+	defer sharedClientMu.Unlock()
+
+	if sharedClient != nil {
+		return sharedClient, nil
+	}
+
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, err
+	}
+	sharedClient = client
+	return sharedClient, nil
+}
@@ -0,0 +1,174 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CreateOrganization stores a new organisation and makes its creator a member with the given membership
+func CreateOrganization(ctx context.Context, org *models.Organization, creatorMembership models.Membership) (*models.Organization, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("auth")
+
+	res, err := db.Collection("organizations").InsertOne(ctx, org)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error creating organization")
+	}
+	objId, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, utils.ErrorHandler(err, "Invalid organization ID")
+	}
+	org.Id = objId.Hex()
+	creatorMembership.OrgId = org.Id
+
+	creatorId, err := primitive.ObjectIDFromHex(org.CreatedBy)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Invalid ID")
+	}
+	_, err = db.Collection("users").UpdateOne(ctx, bson.M{"_id": creatorId}, bson.M{"$push": bson.M{"memberships": creatorMembership}})
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error adding organization creator")
+	}
+
+	return org, nil
+}
+
+// GetOrganizations returns the organisations with the given ids. Unknown ids are skipped
+func GetOrganizations(ctx context.Context, orgIds []string) ([]models.Organization, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objIds := make(bson.A, 0, len(orgIds))
+	for _, orgId := range orgIds {
+		objId, err := primitive.ObjectIDFromHex(orgId)
+		if err == nil {
+			objIds = append(objIds, objId)
+		}
+	}
+
+	cursor, err := client.Database("auth").Collection("organizations").Find(ctx, bson.M{"_id": bson.M{"$in": objIds}})
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading organizations")
+	}
+	defer cursor.Close(ctx)
+
+	var orgs []models.Organization
+	err = cursor.All(ctx, &orgs)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading organizations")
+	}
+	return orgs, nil
+}
+
+// GetOrganization returns an organisation, or nil if it does not exist
+func GetOrganization(ctx context.Context, orgId string) (*models.Organization, error) {
+	orgs, err := GetOrganizations(ctx, []string{orgId})
+	if err != nil || len(orgs) == 0 {
+		return nil, err
+	}
+	return &orgs[0], nil
+}
+
+// AddMembership adds a user to an organisation. It returns false if the user does not exist or already is a member
+func AddMembership(ctx context.Context, userId string, membership models.Membership) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{"_id": objId, "memberships.org_id": bson.M{"$ne": membership.OrgId}}
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, bson.M{"$push": bson.M{"memberships": membership}})
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error adding organization member")
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// RemoveMembership removes a user from an organisation. It returns false if they were not a member
+func RemoveMembership(ctx context.Context, userId, orgId string) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{"_id": objId, "memberships.org_id": orgId}
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"memberships": bson.M{"org_id": orgId}}})
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error removing organization member")
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// SetMembershipRoles replaces a member's roles in an organisation and returns the user as it was before,
+// or nil if they are not a member
+func SetMembershipRoles(ctx context.Context, userId, orgId string, roles []string) (*models.User, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{"_id": objId, "memberships.org_id": orgId}
+	update := bson.M{"$set": bson.M{"memberships.$.roles": roles}}
+
+	var previousUser models.User
+	err = client.Database("auth").Collection("users").FindOneAndUpdate(ctx, filter, update).Decode(&previousUser)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error updating organization member")
+	}
+	return &previousUser, nil
+}
+
+// CountActiveMembersWithRole counts members of an organisation with a role there that are neither suspended
+// nor deleted
+func CountActiveMembersWithRole(ctx context.Context, orgId, role string) (int64, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	filter := bson.M{
+		"memberships": bson.M{"$elemMatch": bson.M{"org_id": orgId, "roles": role}},
+		"status":      bson.M{"$nin": bson.A{models.UserStatusSuspended, models.UserStatusDeleted}},
+	}
+
+	count, err := client.Database("auth").Collection("users").CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error counting organization members")
+	}
+	return count, nil
+}
@@ -0,0 +1,110 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsurePasskeyIndexes creates the unique index that keeps a credential id from being registered to two users
+func EnsurePasskeyIndexes(ctx context.Context) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	_, err = client.Database("auth").Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"passkeys.credential_id": 1},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"passkeys.credential_id": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return utils.ErrorHandler(err, "Error creating passkey index")
+	}
+
+	return nil
+}
+
+// AddPasskey stores a newly registered WebAuthn credential on the user.
+// It returns false if the credential id is already registered, to this or another user
+func AddPasskey(ctx context.Context, userId string, passkey models.Passkey) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{"_id": objId, "passkeys.credential_id": bson.M{"$ne": passkey.CredentialId}}
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, bson.M{"$push": bson.M{"passkeys": passkey}})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error storing passkey")
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+// GetUserByPasskeyCredentialId finds the user owning a WebAuthn credential
+func GetUserByPasskeyCredentialId(ctx context.Context, credentialId []byte) (*models.User, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to the database")
+	}
+	defer client.Disconnect(ctx)
+
+	filter := bson.M{"passkeys.credential_id": credentialId}
+
+	var user models.User
+	err = client.Database("auth").Collection("users").FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil // Return nil without error to indicate user doesn't exist
+		}
+		return nil, utils.ErrorHandler(err, "Internal error")
+	}
+	return &user, nil
+}
+
+// UpdatePasskeyUsage records the signature counter and backup state reported by the authenticator on login
+func UpdatePasskeyUsage(ctx context.Context, userId string, credentialId []byte, signCount uint32, backupState bool) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	filter := bson.M{"_id": objId, "passkeys.credential_id": credentialId}
+	update := bson.M{
+		"$set": bson.M{
+			"passkeys.$.sign_count":   signCount,
+			"passkeys.$.backup_state": backupState,
+			"passkeys.$.last_used_at": time.Now(),
+		},
+	}
+
+	_, err = client.Database("auth").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error updating passkey")
+	}
+
+	return nil
+}
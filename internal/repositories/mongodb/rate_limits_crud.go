@@ -0,0 +1,82 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/pkg/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoRateLimiter keeps token buckets in the rate_limits collection so all replicas share the same limits.
+// Unlike the CRUD helpers it keeps one client open, since it is consulted on every request
+type MongoRateLimiter struct {
+	collection *mongo.Collection
+}
+
+func NewMongoRateLimiter(ctx context.Context) (*MongoRateLimiter, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+
+	collection := client.Database("auth").Collection("rate_limits")
+
+	// Idle buckets are full again after at most an hour with any sensible rule, so they can expire
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"updated_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(3600),
+	})
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error creating rate limit index")
+	}
+
+	return &MongoRateLimiter{collection: collection}, nil
+}
+
+// Allow refills and takes a token in a single atomic update, so concurrent replicas never overspend a bucket
+func (limiter *MongoRateLimiter) Allow(ctx context.Context, key string, rule utils.RateLimitRule) (bool, time.Duration, error) {
+	now := time.Now()
+	burst := float64(rule.Burst)
+
+	elapsedSeconds := bson.M{"$divide": bson.A{
+		bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated_at", now}}}},
+		1000,
+	}}
+	refilled := bson.M{"$min": bson.A{
+		burst,
+		bson.M{"$add": bson.A{
+			bson.M{"$ifNull": bson.A{"$tokens", burst}},
+			bson.M{"$multiply": bson.A{elapsedSeconds, rule.Rate}},
+		}},
+	}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refilled, "updated_at": now}}},
+		{{Key: "$set", Value: bson.M{
+			"allowed": bson.M{"$gte": bson.A{"$tokens", 1}},
+			"tokens": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{"$tokens", 1}},
+				bson.M{"$subtract": bson.A{"$tokens", 1}},
+				"$tokens",
+			}},
+		}}},
+	}
+
+	var bucket struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := limiter.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&bucket)
+	if err != nil {
+		return false, 0, utils.ErrorHandler(err, "Error updating rate limit")
+	}
+
+	if !bucket.Allowed {
+		return false, time.Duration((1 - bucket.Tokens) / rule.Rate * float64(time.Second)), nil
+	}
+	return true, 0, nil
+}
@@ -0,0 +1,218 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoTupleStore keeps relation tuples in the relation_tuples collection, shared by all replicas, and the
+// revision counter in relation_revisions. Like the rate limiter it keeps one client open, since every check
+// reads tuples. Writes do not need transactions: a revision is only committed once all of its tuples are written
+type MongoTupleStore struct {
+	tuples    *mongo.Collection
+	revisions *mongo.Collection
+}
+
+func NewMongoTupleStore(ctx context.Context) (*MongoTupleStore, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+
+	db := client.Database("auth")
+	tuples := db.Collection("relation_tuples")
+
+	_, err = tuples.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// At most one live version of each tuple
+			Keys: bson.D{
+				{Key: "namespace", Value: 1}, {Key: "object_id", Value: 1}, {Key: "relation", Value: 1},
+				{Key: "subject_namespace", Value: 1}, {Key: "subject_id", Value: 1}, {Key: "subject_relation", Value: 1},
+			},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"deleted_rev": 0}),
+		},
+		{
+			Keys: bson.D{
+				{Key: "namespace", Value: 1}, {Key: "object_id", Value: 1}, {Key: "relation", Value: 1}, {Key: "created_rev", Value: 1},
+			},
+		},
+		{
+			// Deleted versions are only needed for reads at older revisions
+			Keys:    bson.M{"deleted_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(int32(utils.RelationHistoryRetention().Seconds())),
+		},
+	})
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error creating relation tuple indexes")
+	}
+
+	return &MongoTupleStore{tuples: tuples, revisions: db.Collection("relation_revisions")}, nil
+}
+
+// relationWriteLease is how long a write may hold the next revision before another write can take it over, so a
+// replica that dies mid-write does not block writes for good
+const relationWriteLease = 30 * time.Second
+
+// relationWriteRetryInterval is how long a write waits before trying again while another one holds the lease
+const relationWriteRetryInterval = 20 * time.Millisecond
+
+// revisionCounter is the relation_revisions document holding the latest committed revision and when it was
+// written. While a write is in progress Lease identifies it and LeaseUntil says when it can be taken over
+type revisionCounter struct {
+	Revision   int64     `bson:"revision"`
+	WrittenAt  time.Time `bson:"written_at"`
+	Lease      string    `bson:"lease,omitempty"`
+	LeaseUntil time.Time `bson:"lease_until,omitempty"`
+}
+
+// Write changes the tuples under the next revision and only then commits it, so reads, which never go past the
+// committed revision, cannot see part of a write or a snapshot that changes after its token was handed out.
+// Writes take turns through a lease on the revision counter. A write that fails or whose replica dies leaves its
+// changes uncommitted, and they become visible with the next write that takes over the same revision
+func (store *MongoTupleStore) Write(ctx context.Context, writes, deletes []utils.RelationTuple) (int64, time.Time, error) {
+	lease := primitive.NewObjectID().Hex()
+	revision, err := store.acquireWriteLease(ctx, lease)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	for _, tuple := range deletes {
+		filter := tupleKey(tuple)
+		filter["deleted_rev"] = 0
+		_, err = store.tuples.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"deleted_rev": revision, "deleted_at": time.Now()}})
+		if err != nil {
+			return 0, time.Time{}, utils.ErrorHandler(err, "Error deleting relation tuple")
+		}
+	}
+
+	for _, tuple := range writes {
+		filter := tupleKey(tuple)
+		filter["deleted_rev"] = 0
+		_, err = store.tuples.UpdateOne(ctx, filter, bson.M{"$setOnInsert": bson.M{"created_rev": revision}}, options.Update().SetUpsert(true))
+		// A write whose lease ran out can still be writing the same tuple, which is just as good
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return 0, time.Time{}, utils.ErrorHandler(err, "Error writing relation tuple")
+		}
+	}
+
+	writtenAt := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"revision": revision, "written_at": writtenAt},
+		"$unset": bson.M{"lease": "", "lease_until": ""},
+	}
+	res, err := store.revisions.UpdateOne(ctx, bson.M{"_id": "tuples", "lease": lease}, update)
+	if err != nil {
+		return 0, time.Time{}, utils.ErrorHandler(err, "Error committing relation revision")
+	}
+	if res.MatchedCount == 0 {
+		return 0, time.Time{}, utils.ErrorHandler(errors.New("write lease expired"), "Error committing relation revision")
+	}
+
+	return revision, writtenAt, nil
+}
+
+// acquireWriteLease waits until no other write holds the revision counter, or its lease has run out, and returns
+// the revision the write is to use: the one after the latest committed revision
+func (store *MongoTupleStore) acquireWriteLease(ctx context.Context, lease string) (int64, error) {
+	for {
+		now := time.Now()
+		filter := bson.M{
+			"_id": "tuples",
+			"$or": bson.A{
+				bson.M{"lease": bson.M{"$exists": false}},
+				bson.M{"lease_until": bson.M{"$lte": now}},
+			},
+		}
+		update := bson.M{"$set": bson.M{"lease": lease, "lease_until": now.Add(relationWriteLease)}}
+
+		var counter revisionCounter
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err := store.revisions.FindOneAndUpdate(ctx, filter, update, opts).Decode(&counter)
+		if err == nil {
+			return counter.Revision + 1, nil
+		}
+		// The upsert collides with the counter while another write holds it
+		if !mongo.IsDuplicateKeyError(err) {
+			return 0, utils.ErrorHandler(err, "Error allocating relation revision")
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, utils.ErrorHandler(ctx.Err(), "Error allocating relation revision")
+		case <-time.After(relationWriteRetryInterval):
+		}
+	}
+}
+
+func (store *MongoTupleStore) Revision(ctx context.Context) (int64, time.Time, error) {
+	var counter revisionCounter
+	err := store.revisions.FindOne(ctx, bson.M{"_id": "tuples"}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, utils.ErrorHandler(err, "Error reading relation revision")
+	}
+	return counter.Revision, counter.WrittenAt, nil
+}
+
+func (store *MongoTupleStore) Read(ctx context.Context, filter utils.TupleFilter, revision int64) ([]utils.RelationTuple, error) {
+	query := bson.M{
+		"created_rev": bson.M{"$lte": revision},
+		"$or": bson.A{
+			bson.M{"deleted_rev": 0},
+			bson.M{"deleted_rev": bson.M{"$gt": revision}},
+		},
+	}
+	if filter.Namespace != "" {
+		query["namespace"] = filter.Namespace
+	}
+	if filter.ObjectId != "" {
+		query["object_id"] = filter.ObjectId
+	}
+	if filter.Relation != "" {
+		query["relation"] = filter.Relation
+	}
+
+	cursor, err := store.tuples.Find(ctx, query)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading relation tuples")
+	}
+	defer cursor.Close(ctx)
+
+	var records []models.RelationTuple
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading relation tuples")
+	}
+
+	tuples := make([]utils.RelationTuple, 0, len(records))
+	for _, record := range records {
+		tuples = append(tuples, utils.RelationTuple{
+			Object:   utils.RelationObject{Namespace: record.Namespace, Id: record.ObjectId},
+			Relation: record.Relation,
+			Subject:  utils.RelationSubject{Namespace: record.SubjectNamespace, Id: record.SubjectId, Relation: record.SubjectRelation},
+		})
+	}
+	return tuples, nil
+}
+
+// tupleKey is the filter matching every version of a tuple
+func tupleKey(tuple utils.RelationTuple) bson.M {
+	return bson.M{
+		"namespace":         tuple.Object.Namespace,
+		"object_id":         tuple.Object.Id,
+		"relation":          tuple.Relation,
+		"subject_namespace": tuple.Subject.Namespace,
+		"subject_id":        tuple.Subject.Id,
+		"subject_relation":  tuple.Subject.Relation,
+	}
+}
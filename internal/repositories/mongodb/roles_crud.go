@@ -0,0 +1,146 @@
+package mongodb
+
+import (
+	"context"
+	"goAuth/internal/models"
+	"goAuth/pkg/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SeedDefaultRoles creates the built-in roles that do not exist yet and adds default permissions introduced
+// since they were created. Other edits made through UpdateRole are left alone
+func SeedDefaultRoles(ctx context.Context) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	collection := client.Database("auth").Collection("roles")
+	for _, role := range utils.DefaultRoles {
+		update := bson.M{
+			"$setOnInsert": bson.M{
+				"description": role.Description,
+				"inherits":    role.Inherits,
+				"built_in":    true,
+				"updated_at":  time.Now(),
+			},
+			"$addToSet": bson.M{"permissions": bson.M{"$each": role.Permissions}},
+		}
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": role.Name}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return utils.ErrorHandler(err, "Error seeding role "+role.Name)
+		}
+	}
+
+	return nil
+}
+
+// GetRoles returns every role in the database
+func GetRoles(ctx context.Context) ([]utils.RoleDefinition, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	cursor, err := client.Database("auth").Collection("roles").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading roles")
+	}
+	defer cursor.Close(ctx)
+
+	var roles []models.Role
+	err = cursor.All(ctx, &roles)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error reading roles")
+	}
+
+	definitions := make([]utils.RoleDefinition, 0, len(roles))
+	for _, role := range roles {
+		definitions = append(definitions, utils.RoleDefinition{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+			Inherits:    role.Inherits,
+			BuiltIn:     role.BuiltIn,
+		})
+	}
+	return definitions, nil
+}
+
+// CreateRole stores a new role. It returns false if a role with this name already exists
+func CreateRole(ctx context.Context, role utils.RoleDefinition) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	_, err = client.Database("auth").Collection("roles").InsertOne(ctx, roleToModel(role))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error creating role")
+	}
+
+	return true, nil
+}
+
+// UpdateRole replaces the description, permissions and inherited roles of a role.
+// It returns false if no role with this name exists
+func UpdateRole(ctx context.Context, role utils.RoleDefinition) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	update := bson.M{
+		"$set": bson.M{
+			"description": role.Description,
+			"permissions": role.Permissions,
+			"inherits":    role.Inherits,
+			"updated_at":  time.Now(),
+		},
+	}
+
+	res, err := client.Database("auth").Collection("roles").UpdateOne(ctx, bson.M{"_id": role.Name}, update)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error updating role")
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+func roleToModel(role utils.RoleDefinition) models.Role {
+	permissions := role.Permissions
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	return models.Role{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: permissions,
+		Inherits:    role.Inherits,
+		BuiltIn:     role.BuiltIn,
+		UpdatedAt:   time.Now(),
+	}
+}
+
+// RefreshRoleRegistry reloads utils.RoleRegistry from the database
+func RefreshRoleRegistry(ctx context.Context) error {
+	roles, err := GetRoles(ctx)
+	if err != nil {
+		return err
+	}
+
+	utils.RoleRegistry.Replace(roles)
+	return nil
+}
@@ -6,12 +6,15 @@ import (
 	"goAuth/internal/models"
 	"goAuth/pkg/utils"
 	pb "goAuth/proto/gen"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// GetUserByUsername finds the account holding a username. Soft deleted users have given it up and are not returned
 func GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	client, err := CreateMongoClient()
 	if err != nil {
@@ -19,13 +22,13 @@ func GetUserByUsername(ctx context.Context, username string) (*models.User, erro
 	}
 	defer client.Disconnect(ctx)
 
-	filter := bson.M{"username": username}
+	filter := bson.M{"username": username, "status": bson.M{"$ne": models.UserStatusDeleted}}
 
 	var user models.User
 	err = client.Database("auth").Collection("users").FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, utils.ErrorHandler(err, "User not found. Incorrect username or password ")
+			return nil, nil // Return nil without error to indicate user doesn't exist
 		}
 		return nil, utils.ErrorHandler(err, "Internal error")
 	}
@@ -33,6 +36,16 @@ func GetUserByUsername(ctx context.Context, username string) (*models.User, erro
 }
 
 func AddUserToDB(ctx context.Context, userFromRequest *pb.RegisterRequest) (*pb.User, error) {
+	return addUserToDB(ctx, userFromRequest, "")
+}
+
+// AddInvitedUserToDB is AddUserToDB for accounts created by accepting an invitation, which the inviting
+// organisation owns
+func AddInvitedUserToDB(ctx context.Context, userFromRequest *pb.RegisterRequest, ownerOrgId string) (*pb.User, error) {
+	return addUserToDB(ctx, userFromRequest, ownerOrgId)
+}
+
+func addUserToDB(ctx context.Context, userFromRequest *pb.RegisterRequest, ownerOrgId string) (*pb.User, error) {
 	client, err := CreateMongoClient()
 	if err != nil {
 		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
@@ -41,10 +54,12 @@ func AddUserToDB(ctx context.Context, userFromRequest *pb.RegisterRequest) (*pb.
 
 	// Create a new user model from the registration request
 	modelUser := &models.User{
-		Username: userFromRequest.Username,
-		Email:    userFromRequest.Email,
-		Password: userFromRequest.Password,
-		Role:     "user", // Auto-set default role
+		Username:   userFromRequest.Username,
+		Email:      userFromRequest.Email,
+		Password:   userFromRequest.Password,
+		Roles:      []string{utils.RoleUser}, // Auto-set default role
+		Status:     models.UserStatusActive,
+		OwnerOrgId: ownerOrgId,
 	}
 
 	// Hash the password before storing
@@ -71,27 +86,54 @@ func AddUserToDB(ctx context.Context, userFromRequest *pb.RegisterRequest) (*pb.
 	return pbUser, nil
 }
 
-func ModifyUserRoleInDB(ctx context.Context, userIdFromReq, updatedRole string) error {
+// ModifyUserRoleInDB replaces a user's roles and returns the user as it was before the change, or nil if no user has this id
+func ModifyUserRoleInDB(ctx context.Context, userIdFromReq string, updatedRoles []string) (*models.User, error) {
 	client, err := CreateMongoClient()
 	if err != nil {
-		return utils.ErrorHandler(err, "Error connecting to mongodb")
+		return nil, utils.ErrorHandler(err, "Error connecting to mongodb")
 	}
 	defer client.Disconnect(ctx)
 
 	objId, err := primitive.ObjectIDFromHex(userIdFromReq)
 	if err != nil {
-		return utils.ErrorHandler(err, "Invalid ID")
+		return nil, utils.ErrorHandler(err, "Invalid ID")
 	}
 
-	_, err = client.Database("auth").Collection("users").UpdateOne(ctx, bson.M{"_id": objId}, bson.M{"$set": bson.M{"role": updatedRole}})
+	var previousUser models.User
+	err = client.Database("auth").Collection("users").FindOneAndUpdate(ctx, bson.M{"_id": objId}, bson.M{"$set": bson.M{"roles": updatedRoles}}).Decode(&previousUser)
 	if err != nil {
-		return utils.ErrorHandler(err, fmt.Sprintf("Error updating user with ID: %s", userIdFromReq))
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, utils.ErrorHandler(err, fmt.Sprintf("Error updating user with ID: %s", userIdFromReq))
 	}
 
-	return nil
+	return &previousUser, nil
 }
 
-// GetUserByEmail finds a user by their email address
+// CountActiveUsersWithRole counts users with a role that are neither suspended nor deleted
+func CountActiveUsersWithRole(ctx context.Context, role string) (int64, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	filter := bson.M{
+		"roles":  role,
+		"status": bson.M{"$nin": bson.A{models.UserStatusSuspended, models.UserStatusDeleted}},
+	}
+
+	count, err := client.Database("auth").Collection("users").CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error counting users")
+	}
+
+	return count, nil
+}
+
+// GetUserByEmail finds a user by their email address, ignoring case, and skips soft deleted users like
+// GetUserByUsername
 func GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	client, err := CreateMongoClient()
 	if err != nil {
@@ -99,10 +141,13 @@ func GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	}
 	defer client.Disconnect(ctx)
 
-	filter := bson.M{"email": email}
+	filter := bson.M{"email": email, "status": bson.M{"$ne": models.UserStatusDeleted}}
+
+	// Strength 2 compares letters and accents but not case
+	opts := options.FindOne().SetCollation(&options.Collation{Locale: "en", Strength: 2})
 
 	var user models.User
-	err = client.Database("auth").Collection("users").FindOne(ctx, filter).Decode(&user)
+	err = client.Database("auth").Collection("users").FindOne(ctx, filter, opts).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil // Return nil without error to indicate user doesn't exist
@@ -147,8 +192,9 @@ func CreateGoogleUser(ctx context.Context, email, name, googleId, picture string
 		Email:    email,
 		GoogleId: googleId,
 		Picture:  picture,
-		Role:     "user", // Auto-set default role
-		Password: "",     // No password for Google OAuth users
+		Roles:    []string{utils.RoleUser}, // Auto-set default role
+		Password: "",                       // No password for Google OAuth users
+		Status:   models.UserStatusActive,
 	}
 
 	res, err := client.Database("auth").Collection("users").InsertOne(ctx, modelUser)
@@ -191,3 +237,125 @@ func UpdateUserGoogleInfo(ctx context.Context, userId, googleId, picture string)
 
 	return nil
 }
+
+// UpdateUserPassword replaces a user's stored password hash
+func UpdateUserPassword(ctx context.Context, userId, hashedPassword string) error {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	_, err = client.Database("auth").Collection("users").UpdateOne(ctx, bson.M{"_id": objId}, bson.M{"$set": bson.M{"password": hashedPassword}})
+	if err != nil {
+		return utils.ErrorHandler(err, "Error updating user password")
+	}
+
+	return nil
+}
+
+// GetUserById finds a user by their ID
+func GetUserById(ctx context.Context, userId string) (*models.User, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error connecting to the database")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	var user models.User
+	err = client.Database("auth").Collection("users").FindOne(ctx, bson.M{"_id": objId}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil // Return nil without error to indicate user doesn't exist
+		}
+		return nil, utils.ErrorHandler(err, "Internal error")
+	}
+	return &user, nil
+}
+
+// ChangeUserPassword stores a new password hash and moves the previous one into the
+// password history, keeping at most historySize-1 old hashes (the current one counts too).
+// It returns false if the stored password is no longer previousHash, e.g. after a concurrent change.
+// revokeTokens also rejects every access token issued so far, like a suspension does
+func ChangeUserPassword(ctx context.Context, userId, previousHash, newHash string, historySize int, revokeTokens bool) (bool, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Invalid ID")
+	}
+
+	set := bson.M{"password": newHash}
+	if revokeTokens {
+		set["tokens_revoked_at"] = time.Now()
+	}
+	update := bson.M{"$set": set}
+	if historySize > 0 && previousHash != "" {
+		update["$push"] = bson.M{
+			"password_history": bson.M{
+				"$each":     bson.A{previousHash},
+				"$position": 0,
+				"$slice":    historySize - 1,
+			},
+		}
+	}
+
+	filter := bson.M{"_id": objId, "password": previousHash}
+	res, err := client.Database("auth").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error updating user password")
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+// MigrateUserRoles moves the single role field of older user documents into the roles array. It is safe to run
+// on every startup and returns how many users were migrated
+func MigrateUserRoles(ctx context.Context) (int64, error) {
+	client, err := CreateMongoClient()
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error connecting to mongodb")
+	}
+	defer client.Disconnect(ctx)
+
+	filter := bson.M{"role": bson.M{"$exists": true}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"roles": bson.M{"$setUnion": bson.A{
+			bson.M{"$ifNull": bson.A{"$roles", bson.A{}}},
+			bson.A{"$role"},
+		}}}}},
+		{{Key: "$unset", Value: "role"}},
+	}
+
+	res, err := client.Database("auth").Collection("users").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error migrating user roles")
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// MongoAccounts is the account store the server looks users up in at login, backed by the users collection
+type MongoAccounts struct{}
+
+func (MongoAccounts) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return GetUserByUsername(ctx, username)
+}
+
+func (MongoAccounts) RecordFailedLogin(ctx context.Context, userId string) (*models.User, error) {
+	return RecordFailedLogin(ctx, userId)
+}
@@ -0,0 +1,331 @@
+package utils
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// Access rule effects. A request is allowed when at least one allow rule and no deny rule matches
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// AccessRule is an attribute based access control rule. Condition is a CEL expression over subject (the caller's
+// id, username, roles and permissions), action, resource (attributes of the target) and request (the request
+// message fields by their proto names). An empty condition always matches
+type AccessRule struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Effect      string   `yaml:"effect"`
+	Actions     []string `yaml:"actions"`
+	Condition   string   `yaml:"condition"`
+}
+
+// AccessInput is what the rules are evaluated against
+type AccessInput struct {
+	Subject  map[string]any
+	Action   string
+	Resource map[string]any
+	Request  map[string]any
+}
+
+// AccessDecision is the outcome of an evaluation and the rule that decided it, if any
+type AccessDecision struct {
+	Allowed bool
+	Rule    string
+	Reason  string
+}
+
+type compiledAccessRule struct {
+	AccessRule
+	program cel.Program
+}
+
+// AccessPolicyStore holds the compiled access rules
+type AccessPolicyStore struct {
+	mu      sync.RWMutex
+	rules   []compiledAccessRule
+	version string
+}
+
+// accessPolicyEnv declares the variables conditions can use
+var accessPolicyEnv = newAccessPolicyEnv()
+
+// newAccessPolicyEnv fails at startup: without the environment no rule could ever be compiled
+func newAccessPolicyEnv() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Variable("subject", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("action", cel.StringType),
+		cel.Variable("resource", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("access policy environment: %v", err))
+	}
+	return env
+}
+
+// Replace compiles a new set of rules and swaps them in. Nothing changes if any rule is invalid
+func (store *AccessPolicyStore) Replace(rules []AccessRule) error {
+	var problems []string
+	compiled := make([]compiledAccessRule, 0, len(rules))
+	names := make(map[string]bool)
+
+	for i, rule := range rules {
+		label := rule.Name
+		if label == "" {
+			label = fmt.Sprintf("rule %d", i+1)
+			problems = append(problems, label+" has no name")
+		} else if names[rule.Name] {
+			problems = append(problems, label+" is defined more than once")
+		}
+		names[rule.Name] = true
+
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			problems = append(problems, fmt.Sprintf("%s has unknown effect %q", label, rule.Effect))
+		}
+		if len(rule.Actions) == 0 {
+			problems = append(problems, label+" has no actions")
+		}
+
+		condition := strings.TrimSpace(rule.Condition)
+		if condition == "" {
+			condition = "true"
+		}
+		ast, issues := accessPolicyEnv.Compile(condition)
+		if issues != nil && issues.Err() != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", label, issues.Err()))
+			continue
+		}
+		if ast.OutputType() != cel.BoolType {
+			problems = append(problems, label+": condition must be a boolean")
+			continue
+		}
+		program, err := accessPolicyEnv.Program(ast)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", label, err))
+			continue
+		}
+
+		compiled = append(compiled, compiledAccessRule{AccessRule: rule, program: program})
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid access policy:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	version := contentVersion(rules)
+
+	store.mu.Lock()
+	store.rules = compiled
+	store.version = version
+	store.mu.Unlock()
+	return nil
+}
+
+// Version changes whenever different rules are loaded
+func (store *AccessPolicyStore) Version() string {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.version
+}
+
+// Rules returns the rules currently in effect
+func (store *AccessPolicyStore) Rules() []AccessRule {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	rules := make([]AccessRule, 0, len(store.rules))
+	for _, rule := range store.rules {
+		rules = append(rules, rule.AccessRule)
+	}
+	return rules
+}
+
+// Evaluate applies every rule covering the action. Deny rules win over allow rules, and actions no allow rule
+// matches are denied. A deny rule whose condition cannot be evaluated (e.g. it reads a missing attribute) denies,
+// while such an allow rule simply does not match
+func (store *AccessPolicyStore) Evaluate(input AccessInput) AccessDecision {
+	store.mu.RLock()
+	rules := store.rules
+	store.mu.RUnlock()
+
+	activation := map[string]any{
+		"subject":  nonNilMap(input.Subject),
+		"action":   input.Action,
+		"resource": nonNilMap(input.Resource),
+		"request":  nonNilMap(input.Request),
+	}
+
+	var allowedBy string
+	for _, rule := range rules {
+		if !actionMatches(rule.Actions, input.Action) {
+			continue
+		}
+
+		matched, err := evalCondition(rule.program, activation)
+		if rule.Effect == EffectDeny {
+			if err != nil {
+				return AccessDecision{Rule: rule.Name, Reason: "rule " + rule.Name + " could not be evaluated: " + err.Error()}
+			}
+			if matched {
+				reason := rule.Description
+				if reason == "" {
+					reason = "denied by rule " + rule.Name
+				}
+				return AccessDecision{Rule: rule.Name, Reason: reason}
+			}
+			continue
+		}
+
+		if err == nil && matched && allowedBy == "" {
+			allowedBy = rule.Name
+		}
+	}
+
+	if allowedBy == "" {
+		return AccessDecision{Reason: "no rule allows " + input.Action}
+	}
+	return AccessDecision{Allowed: true, Rule: allowedBy}
+}
+
+func evalCondition(program cel.Program, activation map[string]any) (bool, error) {
+	value, _, err := program.Eval(activation)
+	if err != nil {
+		return false, err
+	}
+	matched, ok := value.Value().(bool)
+	if !ok {
+		return false, errors.New("condition did not return a boolean")
+	}
+	return matched, nil
+}
+
+// actionMatches supports exact actions, "*" and prefixes such as "users.*"
+func actionMatches(patterns []string, action string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == action {
+			return true
+		}
+		if strings.HasSuffix(pattern, ".*") && strings.HasPrefix(action, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func nonNilMap(values map[string]any) map[string]any {
+	if values == nil {
+		return map[string]any{}
+	}
+	return values
+}
+
+// AccessSubject describes a user for access rules, including the permissions granted by their roles.
+// orgId is the organisation their token is scoped to, if any
+func AccessSubject(userId, username, orgId string, roles []string) map[string]any {
+	granted := make(map[string]bool)
+	for _, role := range roles {
+		for _, permission := range RoleRegistry.EffectivePermissions(role) {
+			granted[permission] = true
+		}
+	}
+	permissions := make([]string, 0, len(granted))
+	for permission := range granted {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+
+	return map[string]any{
+		"id":          userId,
+		"username":    username,
+		"org_id":      orgId,
+		"roles":       append([]string{}, roles...),
+		"permissions": permissions,
+	}
+}
+
+// CallerSubject describes the authenticated caller for access rules
+func CallerSubject(ctx context.Context) map[string]any {
+	userId, _ := ctx.Value(ContextKey("userId")).(string)
+	username, _ := ctx.Value(ContextKey("username")).(string)
+	roles, _ := CallerRoles(ctx)
+	return AccessSubject(userId, username, CallerOrganization(ctx), roles)
+}
+
+// RequestAttributes exposes the fields of a request message to access rules by their proto names.
+// Unset fields are included with their zero value so rules do not need to check for them
+func RequestAttributes(req proto.Message) (map[string]any, error) {
+	if req == nil {
+		return map[string]any{}, nil
+	}
+
+	data, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(req)
+	if err != nil {
+		return nil, ErrorHandler(err, "Error reading request attributes")
+	}
+
+	var attributes map[string]any
+	err = json.Unmarshal(data, &attributes)
+	if err != nil {
+		return nil, ErrorHandler(err, "Error reading request attributes")
+	}
+	return attributes, nil
+}
+
+// AuthorizeAccess evaluates the access rules for the caller performing an action on a resource
+func AuthorizeAccess(ctx context.Context, action string, resource map[string]any, req proto.Message) (AccessDecision, error) {
+	request, err := RequestAttributes(req)
+	if err != nil {
+		return AccessDecision{}, err
+	}
+
+	return AccessPolicies.Evaluate(AccessInput{
+		Subject:  CallerSubject(ctx),
+		Action:   action,
+		Resource: resource,
+		Request:  request,
+	}), nil
+}
+
+// AccessPolicies is empty, and so denies everything, until the policy has been loaded on startup
+var AccessPolicies = &AccessPolicyStore{}
+
+//go:embed access_policy.yaml
+var defaultAccessPolicy []byte
+
+// LoadAccessPolicy reads the access rules from the file named by ACCESS_POLICY_FILE, or the built-in
+// access_policy.yaml when it is not set, and installs them in AccessPolicies
+func LoadAccessPolicy() error {
+	data := defaultAccessPolicy
+	if path := os.Getenv("ACCESS_POLICY_FILE"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return ErrorHandler(err, "Error reading access policy")
+		}
+	}
+
+	var policy struct {
+		Rules []AccessRule `yaml:"rules"`
+	}
+	err := yaml.Unmarshal(data, &policy)
+	if err != nil {
+		return ErrorHandler(err, "Error parsing access policy")
+	}
+
+	return AccessPolicies.Replace(policy.Rules)
+}
@@ -0,0 +1,70 @@
+package utils
+
+import "testing"
+
+func orgAdminSubject(orgId string, permissions ...string) map[string]any {
+	return map[string]any{
+		"id":          "admin",
+		"username":    "admin",
+		"org_id":      orgId,
+		"roles":       []string{RoleAdmin},
+		"permissions": permissions,
+	}
+}
+
+func TestOrganizationAdminsOnlyActOnOwnedAccounts(t *testing.T) {
+	err := LoadAccessPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject := orgAdminSubject("acme", PermUsersSuspend, PermUsersDelete, PermUsersUnlock)
+
+	cases := []struct {
+		name     string
+		resource map[string]any
+		allowed  bool
+	}{
+		{
+			name:     "self-registered account",
+			resource: map[string]any{"type": "user", "roles": []string{RoleUser}, "org_roles": []string{RoleUser}, "org_ids": []string{"acme"}},
+		},
+		{
+			name:     "account owned by another organisation",
+			resource: map[string]any{"type": "user", "roles": []string{RoleUser}, "org_roles": []string{RoleUser}, "org_ids": []string{"acme"}, "owner_org_id": "other"},
+		},
+		{
+			name:     "account owned by the organisation",
+			resource: map[string]any{"type": "user", "roles": []string{RoleUser}, "org_roles": []string{RoleUser}, "org_ids": []string{"acme"}, "owner_org_id": "acme"},
+			allowed:  true,
+		},
+		{
+			name:     "owned account that is a global admin",
+			resource: map[string]any{"type": "user", "roles": []string{RoleAdmin}, "org_roles": []string{RoleUser}, "org_ids": []string{"acme"}, "owner_org_id": "acme"},
+		},
+	}
+
+	for _, c := range cases {
+		for _, action := range []string{PermUsersSuspend, PermUsersDelete} {
+			decision := AccessPolicies.Evaluate(AccessInput{Subject: subject, Action: action, Resource: c.resource})
+			if decision.Allowed != c.allowed {
+				t.Errorf("%s, %s: allowed %v (%s), want %v", c.name, action, decision.Allowed, decision.Reason, c.allowed)
+			}
+		}
+	}
+}
+
+func TestGlobalAdminsActOnAnyAccount(t *testing.T) {
+	err := LoadAccessPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decision := AccessPolicies.Evaluate(AccessInput{
+		Subject:  orgAdminSubject("", PermUsersSuspend),
+		Action:   PermUsersSuspend,
+		Resource: map[string]any{"type": "user", "roles": []string{RoleUser}, "org_ids": []string{"acme"}},
+	})
+	if !decision.Allowed {
+		t.Fatalf("global admin denied: %s", decision.Reason)
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+)
+
+// AuditChainKey returns the HMAC key of the audit hash chain (AUDIT_CHAIN_KEY). It is separate from JWT_SECRET so
+// rotating the token secret does not invalidate old events, and without it events cannot be rewritten unnoticed
+// by someone who can only write to the database
+func AuditChainKey() ([]byte, error) {
+	key := os.Getenv("AUDIT_CHAIN_KEY")
+	if key == "" {
+		return nil, errors.New("AUDIT_CHAIN_KEY environment variable is not set")
+	}
+	return []byte(key), nil
+}
+
+// AuditInfo collects who did what to whom while a request is handled. The audit interceptor creates it,
+// the authentication interceptor and handlers fill it in, and it is written out once the handler returns
+type AuditInfo struct {
+	mu            sync.Mutex
+	ActorId       string
+	ActorUsername string
+	TargetId      string
+	Details       map[string]string
+}
+
+// WithAuditInfo attaches an empty AuditInfo to the context
+func WithAuditInfo(ctx context.Context) (context.Context, *AuditInfo) {
+	info := &AuditInfo{Details: make(map[string]string)}
+	return context.WithValue(ctx, ContextKey("audit"), info), info
+}
+
+func auditInfo(ctx context.Context) *AuditInfo {
+	info, _ := ctx.Value(ContextKey("audit")).(*AuditInfo)
+	return info
+}
+
+// SetAuditActor records the user performing the request
+func SetAuditActor(ctx context.Context, userId, username string) {
+	info := auditInfo(ctx)
+	if info == nil {
+		return
+	}
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	info.ActorId = userId
+	info.ActorUsername = username
+}
+
+// SetAuditTarget records the user the request acts upon
+func SetAuditTarget(ctx context.Context, userId string) {
+	info := auditInfo(ctx)
+	if info == nil {
+		return
+	}
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	info.TargetId = userId
+}
+
+// AddAuditDetail records an extra key/value pair, e.g. the old and new role of a role change
+func AddAuditDetail(ctx context.Context, key, value string) {
+	info := auditInfo(ctx)
+	if info == nil {
+		return
+	}
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	info.Details[key] = value
+}
+
+// Snapshot returns a copy of the collected values that is safe to use after the request
+func (info *AuditInfo) Snapshot() (actorId, actorUsername, targetId string, details map[string]string) {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+
+	details = make(map[string]string, len(info.Details))
+	for key, value := range info.Details {
+		details[key] = value
+	}
+	return info.ActorId, info.ActorUsername, info.TargetId, details
+}
@@ -3,22 +3,58 @@ package utils
 import (
 	"context"
 	"errors"
-	"fmt"
+	"os"
+	"slices"
+	"time"
 )
 
 type ContextKey string
 
+// CallerRoles returns the roles of the authenticated caller, as set by the authentication interceptor
+func CallerRoles(ctx context.Context) ([]string, bool) {
+	roles, ok := ctx.Value(ContextKey("roles")).([]string)
+	return roles, ok
+}
+
+// CallerOrganization returns the organisation the caller's token is scoped to, or "" for a global token
+func CallerOrganization(ctx context.Context) string {
+	orgId, _ := ctx.Value(ContextKey("orgId")).(string)
+	return orgId
+}
+
+// AuthorizeUser succeeds if the caller has at least one of the allowed roles
 func AuthorizeUser(ctx context.Context, allowedRoles ...string) error {
-	userRole, ok := ctx.Value(ContextKey("role")).(string)
-	fmt.Println("***********************", userRole)
+	userRoles, ok := CallerRoles(ctx)
 	if !ok {
 		return errors.New("user not authorized for access: role not found")
 	}
 
 	for _, allowedRole := range allowedRoles {
-		if allowedRole == userRole {
+		if slices.Contains(userRoles, allowedRole) {
 			return nil
 		}
 	}
 	return errors.New("user not authorized for access: insufficient permissions")
 }
+
+// RequireRecentAuth makes sure the caller's token was issued recently (RECENT_AUTH_MAX_AGE, 5 minutes by default),
+// i.e. the user just proved their credentials, before allowing sensitive operations
+func RequireRecentAuth(ctx context.Context) error {
+	issuedAt, ok := ctx.Value(ContextKey("issuedAt")).(int64)
+	if !ok {
+		return errors.New("recent authentication required: please login again")
+	}
+
+	maxAge := 5 * time.Minute
+	if value := os.Getenv("RECENT_AUTH_MAX_AGE"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err == nil {
+			maxAge = duration
+		}
+	}
+
+	if time.Since(time.Unix(issuedAt, 0)) > maxAge {
+		return errors.New("recent authentication required: please login again")
+	}
+	return nil
+}
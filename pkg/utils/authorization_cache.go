@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Upper bound on cached decisions so a flood of distinct requests cannot grow the cache without limit
+const authorizationCacheMaxEntries = 100000
+
+// contentVersion hashes a value so that equal content always gets the same version
+func contentVersion(value any) string {
+	data, _ := json.Marshal(value)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// PolicyVersion identifies the roles and access rules currently in effect. It changes whenever either does,
+// so decisions cached under an older version are never served
+func PolicyVersion() string {
+	return contentVersion([]string{RoleRegistry.Version(), AccessPolicies.Version()})
+}
+
+// AuthorizationCacheTTL is how long Authorize decisions are reused (AUTHZ_CACHE_TTL, 30 seconds by default,
+// 0 disables caching). Changes to the resource, such as a target user's roles, take up to this long to apply
+func AuthorizationCacheTTL() time.Duration {
+	return durationFromEnv("AUTHZ_CACHE_TTL", 30*time.Second)
+}
+
+// AuthorizationCacheKey identifies a decision by subject, policy version and everything else that was asked
+func AuthorizationCacheKey(subject, policyVersion string, request any) string {
+	data, _ := json.Marshal(request)
+	sum := sha256.Sum256(append([]byte(subject+"\x00"+policyVersion+"\x00"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// AccessSubjectKey identifies a subject from AccessSubject in cache keys, so a decision is only reused for a subject
+// with the same identity, organisation and roles
+func AccessSubjectKey(subject map[string]any) string {
+	data, _ := json.Marshal(subject)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type cachedDecision struct {
+	decision  AccessDecision
+	expiresAt time.Time
+}
+
+// AuthorizationCacheStore keeps recent Authorize decisions in memory
+type AuthorizationCacheStore struct {
+	mu      sync.Mutex
+	entries map[string]cachedDecision
+}
+
+// Get returns a cached decision that has not expired yet
+func (store *AuthorizationCacheStore) Get(key string) (AccessDecision, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, ok := store.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return AccessDecision{}, false
+	}
+	return entry.decision, true
+}
+
+// Put caches a decision until expiresAt
+func (store *AuthorizationCacheStore) Put(key string, decision AccessDecision, expiresAt time.Time) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if len(store.entries) >= authorizationCacheMaxEntries {
+		return
+	}
+	store.entries[key] = cachedDecision{decision: decision, expiresAt: expiresAt}
+}
+
+func (store *AuthorizationCacheStore) CleanUpExpiredEntries() {
+	for {
+		time.Sleep(2 * time.Minute)
+
+		store.mu.Lock()
+		for key, entry := range store.entries {
+			if time.Now().After(entry.expiresAt) {
+				delete(store.entries, key)
+			}
+		}
+		store.mu.Unlock()
+	}
+}
+
+var AuthorizationCache = &AuthorizationCacheStore{
+	entries: make(map[string]cachedDecision),
+}
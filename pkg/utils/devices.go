@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// KnownDevicesMax is how many device/network combinations are remembered per user
+const KnownDevicesMax = 20
+
+// DeviceFingerprint identifies the client device from its user agent and the optional x-device-id metadata,
+// a random id the client generates once and keeps in local storage
+func DeviceFingerprint(ctx context.Context) string {
+	deviceId := ""
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok && len(md["x-device-id"]) > 0 {
+		deviceId = md["x-device-id"][0]
+	}
+
+	sum := sha256.Sum256([]byte(UserAgent(ctx) + "\x00" + deviceId))
+	return hex.EncodeToString(sum[:])
+}
+
+// IPPrefix returns the /24 network of an IPv4 address or the /48 network of an IPv6 address,
+// so moving between addresses of the same provider does not look like a new network
+func IPPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	if ipv4 := parsed.To4(); ipv4 != nil {
+		return (&net.IPNet{IP: ipv4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// NewDeviceMfaRequired reports whether password logins from an unfamiliar device or network must be
+// confirmed with an emailed code (NEW_DEVICE_REQUIRE_MFA=true). Users with TOTP always confirm with TOTP
+func NewDeviceMfaRequired() bool {
+	return os.Getenv("NEW_DEVICE_REQUIRE_MFA") == "true"
+}
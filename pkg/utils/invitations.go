@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const defaultInvitationExpiresIn = 7 * 24 * time.Hour
+
+// InvitationExpiresIn is how long an organisation invitation can be accepted (INVITATION_EXPIRES_IN)
+func InvitationExpiresIn() time.Duration {
+	return durationFromEnv("INVITATION_EXPIRES_IN", defaultInvitationExpiresIn)
+}
+
+// Invitation sending limits, each overridable through the environment:
+//
+//	INVITATION_LIMIT_WINDOW   period the limits below apply to
+//	INVITATION_LIMIT_PER_ORG  invitations an organisation can send per window (0 disables the limit)
+//	INVITATION_LIMIT_PER_USER invitations a user can send per window, across organisations (0 disables the limit)
+const (
+	defaultInvitationLimitWindow  = 24 * time.Hour
+	defaultInvitationLimitPerOrg  = 100
+	defaultInvitationLimitPerUser = 20
+)
+
+func InvitationLimitWindow() time.Duration {
+	return durationFromEnv("INVITATION_LIMIT_WINDOW", defaultInvitationLimitWindow)
+}
+
+func InvitationLimitPerOrg() int {
+	return intFromEnv("INVITATION_LIMIT_PER_ORG", defaultInvitationLimitPerOrg)
+}
+
+func InvitationLimitPerUser() int {
+	return intFromEnv("INVITATION_LIMIT_PER_USER", defaultInvitationLimitPerUser)
+}
+
+// NormalizeEmail trims and lowercases an email address, so invitations do not depend on how it was typed
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// SignInvitationToken returns the token emailed with an invitation. It only identifies the invitation, which
+// must still be pending when the token is used, so revoking the invitation also invalidates the token
+func SignInvitationToken(invitationId string, expiresAt time.Time) (string, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	claims := jwt.MapClaims{
+		"inv": invitationId,
+		"typ": "invitation",
+		"exp": jwt.NewNumericDate(expiresAt),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtSecret))
+}
+
+// ParseInvitationToken validates an invitation token and returns the invitation id it carries
+func ParseInvitationToken(tokenStr string) (string, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	parsedToken, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
+		return []byte(jwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !parsedToken.Valid {
+		return "", errors.New("invalid or expired invitation")
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "invitation" {
+		return "", errors.New("invalid invitation")
+	}
+
+	invitationId, _ := claims["inv"].(string)
+	if invitationId == "" {
+		return "", errors.New("invalid invitation")
+	}
+
+	return invitationId, nil
+}
+
+// InvitationLink builds the URL emailed with an invitation from INVITATION_URL
+// (e.g. https://app.example.com/invitations/accept). Without it the token is sent on its own
+func InvitationLink(token string) string {
+	baseUrl := os.Getenv("INVITATION_URL")
+	if baseUrl == "" {
+		return token
+	}
+
+	link, err := url.Parse(baseUrl)
+	if err != nil {
+		ErrorHandler(err, "Invalid INVITATION_URL")
+		return token
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String()
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"sync"
 	"time"
@@ -9,29 +10,55 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func SignToken(userId, username, role string) (string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", errors.New("JWT_SECRET environment variable is not set")
-	}
-
-	jwtExpiresIn := os.Getenv("JWT_EXPIRES_IN")
+func SignToken(userId, username string, roles []string) (string, error) {
+	return signAccessToken(jwt.MapClaims{
+		"uid":   userId,
+		"user":  username,
+		"roles": roles,
+	})
+}
 
+// ReissueAccessToken issues a token for another scope with the iat and exp of the token it replaces, so switching
+// scopes never extends a session. An empty orgId gives a token with the user's global roles, and a zero issuedAt
+// leaves out iat like the token being replaced
+func ReissueAccessToken(userId, username, orgId string, roles []string, issuedAt, expiresAt time.Time) (string, error) {
 	claims := jwt.MapClaims{
-		"uid":  userId,
-		"user": username,
-		"role": role,
+		"uid":   userId,
+		"user":  username,
+		"roles": roles,
+	}
+	if orgId != "" {
+		claims["org_id"] = orgId
 	}
+	return signAccessTokenAt(claims, issuedAt, expiresAt)
+}
+
+func signAccessToken(claims jwt.MapClaims) (string, error) {
+	jwtExpiresIn := os.Getenv("JWT_EXPIRES_IN")
 
+	now := time.Now()
+	expiresAt := now.Add(15 * time.Minute)
 	if jwtExpiresIn != "" {
 		duration, err := time.ParseDuration(jwtExpiresIn)
 		if err != nil {
 			return "", errors.New("internal error")
 		}
-		claims["exp"] = jwt.NewNumericDate(time.Now().Add(duration))
-	} else {
-		claims["exp"] = jwt.NewNumericDate(time.Now().Add(15 * time.Minute))
+		expiresAt = now.Add(duration)
+	}
+
+	return signAccessTokenAt(claims, now, expiresAt)
+}
+
+func signAccessTokenAt(claims jwt.MapClaims, issuedAt, expiresAt time.Time) (string, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	if !issuedAt.IsZero() {
+		claims["iat"] = jwt.NewNumericDate(issuedAt)
 	}
+	claims["exp"] = jwt.NewNumericDate(expiresAt)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
@@ -43,6 +70,137 @@ func SignToken(userId, username, role string) (string, error) {
 	return signedToken, nil
 }
 
+// AccessClaims are the claims of a valid access token
+type AccessClaims struct {
+	UserId    string
+	Username  string
+	Roles     []string
+	ExpiresAt int64
+	// Zero for tokens issued before the iat claim was introduced
+	IssuedAt int64
+	// The organisation the token is scoped to, empty for tokens carrying the user's global roles
+	OrgId string
+}
+
+// ParseAccessToken checks the signature and expiry of an access token and reads its claims. It does not check
+// whether the token was blacklisted or revoked
+func ParseAccessToken(tokenStr string) (*AccessClaims, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return nil, errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	parsedToken, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
+		_, ok := token.Method.(*jwt.SigningMethodHMAC)
+		if !ok {
+			return nil, fmt.Errorf("invalid signing method: %v", token.Method.Alg())
+		}
+		return []byte(jwtSecret), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("token parsing failed: %w", err)
+	}
+	if !parsedToken.Valid {
+		return nil, errors.New("token is not valid")
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("cannot parse claims")
+	}
+
+	// MFA challenge tokens and other special purpose tokens are never valid access tokens
+	if tokenType, ok := claims["typ"]; ok && tokenType != "" {
+		return nil, errors.New("token is not an access token")
+	}
+
+	roles, ok := RolesFromClaims(claims)
+	if !ok {
+		return nil, errors.New("roles claim missing")
+	}
+
+	userId, ok := claims["uid"].(string)
+	if !ok {
+		return nil, errors.New("uid claim missing")
+	}
+
+	username, ok := claims["user"].(string)
+	if !ok {
+		return nil, errors.New("user claim missing")
+	}
+
+	expiresAt, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("expiry claim missing")
+	}
+
+	issuedAt, _ := claims["iat"].(float64)
+	orgId, _ := claims["org_id"].(string)
+
+	return &AccessClaims{
+		UserId:    userId,
+		Username:  username,
+		Roles:     roles,
+		ExpiresAt: int64(expiresAt),
+		IssuedAt:  int64(issuedAt),
+		OrgId:     orgId,
+	}, nil
+}
+
+// Lifetime of the challenge token handed out when a login still needs a second factor
+const mfaTokenExpiresIn = 5 * time.Minute
+
+// MfaMaxAttempts is how many wrong codes one MFA challenge token allows before the login must start over
+const MfaMaxAttempts = 5
+
+// SignMfaToken issues a short lived token proving the password step of a login succeeded.
+// It carries a "typ" claim so the authentication interceptor never accepts it as an access token, and the
+// user's failed login count when it was issued so VerifyMfa can tell how many codes were tried with it
+func SignMfaToken(userId string, failedAttempts int) (string, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	claims := jwt.MapClaims{
+		"uid": userId,
+		"typ": "mfa",
+		"fla": failedAttempts,
+		"exp": jwt.NewNumericDate(time.Now().Add(mfaTokenExpiresIn)),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(jwtSecret))
+}
+
+// ParseMfaToken validates an MFA challenge token and returns the user id and failed login count it was issued with
+func ParseMfaToken(tokenStr string) (string, int, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", 0, errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	parsedToken, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
+		return []byte(jwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !parsedToken.Valid {
+		return "", 0, errors.New("invalid or expired mfa token")
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "mfa" {
+		return "", 0, errors.New("invalid mfa token")
+	}
+
+	userId, ok := claims["uid"].(string)
+	if !ok || userId == "" {
+		return "", 0, errors.New("invalid mfa token")
+	}
+	failedAttempts, _ := claims["fla"].(float64)
+
+	return userId, int(failedAttempts), nil
+}
+
 // Acts as an in memory database where we store valid tokens
 // Important to make it concurrency-safe since multiple requests may arrive at the same time
 type JWTStore struct {
@@ -83,3 +241,25 @@ func (store *JWTStore) IsBlacklisted(token string) bool {
 var JwtStore = JWTStore{
 	Tokens: make(map[string]time.Time),
 }
+
+// RolesFromClaims reads the roles claim, falling back to the single role claim of tokens issued before users
+// could have several roles
+func RolesFromClaims(claims jwt.MapClaims) ([]string, bool) {
+	if list, ok := claims["roles"].([]interface{}); ok {
+		roles := make([]string, 0, len(list))
+		for _, item := range list {
+			role, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			roles = append(roles, role)
+		}
+		return roles, true
+	}
+
+	role, ok := claims["role"].(string)
+	if !ok {
+		return nil, false
+	}
+	return []string{role}, true
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReissueAccessTokenKeepsLifetime(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	issuedAt := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	expiresAt := time.Now().Add(5 * time.Minute).Truncate(time.Second)
+
+	token, err := ReissueAccessToken("64b7f0c2a1b2c3d4e5f60718", "alice", "acme", []string{RoleAdmin}, issuedAt, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.IssuedAt != issuedAt.Unix() || claims.ExpiresAt != expiresAt.Unix() {
+		t.Fatalf("token issued at %d and expiring at %d, want %d and %d", claims.IssuedAt, claims.ExpiresAt, issuedAt.Unix(), expiresAt.Unix())
+	}
+	if claims.OrgId != "acme" {
+		t.Fatalf("token scoped to %q, want acme", claims.OrgId)
+	}
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Hash formats accepted from legacy systems during migration:
+//
+//	bcrypt:         $2a$10$<22 char salt><31 char hash>  (also $2b$ and $2y$)
+//	scrypt:         $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>   (passlib, adapted base64)
+//	PBKDF2-SHA256:  $pbkdf2-sha256$<iterations>$<salt>$<hash>       (passlib, adapted base64)
+//	                pbkdf2_sha256$<iterations>$<salt>$<hash>        (Django, raw salt + base64 hash)
+const (
+	scryptPrefix       = "$scrypt$"
+	pbkdf2Prefix       = "$pbkdf2-sha256$"
+	djangoPbkdf2Prefix = "pbkdf2_sha256$"
+)
+
+// Upper bounds on the parameters read from a stored hash, so a corrupt or crafted hash cannot make a login
+// attempt allocate gigabytes or spin the CPU. They leave room above the defaults of passlib and Django
+const (
+	// N·r·p for scrypt; scrypt needs 128·N·r bytes, 256 MiB at this bound with p = 1
+	maxScryptCost = 1 << 21
+	// Django 5.1 uses 870000
+	maxPbkdf2Iterations = 2_000_000
+	// Longest derived key accepted, PBKDF2 cost grows with it
+	maxLegacyHashLength = 64
+)
+
+func isBcryptHash(storedPassword string) bool {
+	return strings.HasPrefix(storedPassword, "$2a$") ||
+		strings.HasPrefix(storedPassword, "$2b$") ||
+		strings.HasPrefix(storedPassword, "$2y$")
+}
+
+func verifyBcrypt(inputPassword, storedPassword string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(inputPassword))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return errors.New("incorrect password")
+		}
+		return errors.New("invalid encoded hash format")
+	}
+	return nil
+}
+
+func verifyScrypt(inputPassword, storedPassword string) error {
+	// "", "scrypt", "ln=16,r=8,p=1", salt, hash
+	parts := strings.Split(storedPassword, "$")
+	if len(parts) != 5 {
+		return errors.New("invalid encoded hash format")
+	}
+
+	var logN, r, p int
+	for _, param := range strings.Split(parts[2], ",") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return errors.New("invalid encoded hash format")
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("invalid encoded hash format")
+		}
+		switch key {
+		case "ln":
+			logN = n
+		case "r":
+			r = n
+		case "p":
+			p = n
+		}
+	}
+	if logN <= 0 || logN > 30 || r <= 0 || p <= 0 {
+		return errors.New("invalid scrypt parameters")
+	}
+	// Checked factor by factor so the product cannot overflow
+	n := 1 << logN
+	if n > maxScryptCost || r > maxScryptCost/n || p > maxScryptCost/(n*r) {
+		return errors.New("invalid scrypt parameters")
+	}
+
+	salt, err := decodeAdaptedBase64(parts[3])
+	if err != nil {
+		return errors.New("failed to decode the salt")
+	}
+
+	hashedPassword, err := decodeAdaptedBase64(parts[4])
+	if err != nil {
+		return errors.New("failed to decode the hashed password")
+	}
+	if len(hashedPassword) > maxLegacyHashLength {
+		return errors.New("invalid encoded hash format")
+	}
+
+	hash, err := scrypt.Key([]byte(inputPassword), salt, n, r, p, len(hashedPassword))
+	if err != nil {
+		return errors.New("invalid scrypt parameters")
+	}
+
+	return compareHashes(hash, hashedPassword)
+}
+
+func verifyPbkdf2(inputPassword, storedPassword string) error {
+	parts := strings.Split(strings.TrimPrefix(storedPassword, "$"), "$")
+	if len(parts) != 4 {
+		return errors.New("invalid encoded hash format")
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 || iterations > maxPbkdf2Iterations {
+		return errors.New("invalid encoded hash format")
+	}
+
+	var salt, hashedPassword []byte
+	if strings.HasPrefix(storedPassword, djangoPbkdf2Prefix) {
+		// Django stores the salt as plain text and the hash as standard base64
+		salt = []byte(parts[2])
+		hashedPassword, err = base64.StdEncoding.DecodeString(parts[3])
+	} else {
+		salt, err = decodeAdaptedBase64(parts[2])
+		if err != nil {
+			return errors.New("failed to decode the salt")
+		}
+		hashedPassword, err = decodeAdaptedBase64(parts[3])
+	}
+	if err != nil {
+		return errors.New("failed to decode the hashed password")
+	}
+	if len(hashedPassword) > maxLegacyHashLength {
+		return errors.New("invalid encoded hash format")
+	}
+
+	hash := pbkdf2.Key([]byte(inputPassword), salt, iterations, len(hashedPassword), sha256.New)
+
+	return compareHashes(hash, hashedPassword)
+}
+
+// decodeAdaptedBase64 decodes passlib's "ab64" encoding: standard base64 with '.' instead of '+' and no padding
+func decodeAdaptedBase64(s string) ([]byte, error) {
+	s = strings.ReplaceAll(s, ".", "+")
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func compareHashes(hash, hashedPassword []byte) error {
+	if len(hash) != len(hashedPassword) || len(hash) == 0 {
+		return errors.New("incorrect password")
+	}
+
+	if subtle.ConstantTimeCompare(hash, hashedPassword) != 1 {
+		return errors.New("incorrect password")
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package utils
+
+import "testing"
+
+// Known answers produced by the systems the hashes are imported from
+var legacyPasswordVectors = []struct {
+	name     string
+	password string
+	hash     string
+}{
+	{
+		name:     "bcrypt (OpenBSD test vector)",
+		password: "U*U",
+		hash:     "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+	},
+	{
+		name:     "scrypt (passlib)",
+		password: "password",
+		hash:     "$scrypt$ln=16,r=8,p=1$aM15713r3Xsvxbi31lqr1Q$nFNh2CVHVjNldFVKDHDlm4CbdRSCdEBsjjJxD+iCs5E",
+	},
+	{
+		name:     "PBKDF2-SHA256 (passlib)",
+		password: "password",
+		hash:     "$pbkdf2-sha256$1212$4vjV83LKPjQzk31VI4E0Vw$hsYF68OiOUPdDZ1Fg.fJPeq1h/gXXY7acBp9/6c.tmQ",
+	},
+	{
+		name:     "PBKDF2-SHA256 (Django)",
+		password: "lètmein",
+		hash:     "pbkdf2_sha256$870000$seasalt$wJSpLMQRQz0Dhj/pFpbyjMj71B2gUYp6HJS5AU+32Ac=",
+	},
+}
+
+func TestVerifyLegacyPasswords(t *testing.T) {
+	for _, vector := range legacyPasswordVectors {
+		if !NeedsRehash(vector.hash) {
+			t.Errorf("%s: not recognised as a legacy hash", vector.name)
+		}
+		err := VerifyPassword(vector.password, vector.hash)
+		if err != nil {
+			t.Errorf("%s: correct password rejected: %v", vector.name, err)
+		}
+		err = VerifyPassword(vector.password+"x", vector.hash)
+		if err == nil {
+			t.Errorf("%s: wrong password accepted", vector.name)
+		}
+	}
+}
+
+func TestVerifyLegacyPasswordsRejectsExpensiveParameters(t *testing.T) {
+	hashes := []string{
+		"$scrypt$ln=30,r=8,p=1$aM15713r3Xsvxbi31lqr1Q$nFNh2CVHVjNldFVKDHDlm4CbdRSCdEBsjjJxD+iCs5E",
+		"$scrypt$ln=16,r=1024,p=1$aM15713r3Xsvxbi31lqr1Q$nFNh2CVHVjNldFVKDHDlm4CbdRSCdEBsjjJxD+iCs5E",
+		"$scrypt$ln=16,r=8,p=100000$aM15713r3Xsvxbi31lqr1Q$nFNh2CVHVjNldFVKDHDlm4CbdRSCdEBsjjJxD+iCs5E",
+		"$pbkdf2-sha256$1000000000$4vjV83LKPjQzk31VI4E0Vw$hsYF68OiOUPdDZ1Fg.fJPeq1h/gXXY7acBp9/6c.tmQ",
+		"pbkdf2_sha256$1000000000$seasalt$wJSpLMQRQz0Dhj/pFpbyjMj71B2gUYp6HJS5AU+32Ac=",
+	}
+	for _, hash := range hashes {
+		err := VerifyPassword("password", hash)
+		if err == nil || err.Error() == "incorrect password" {
+			t.Errorf("%s: expected a parameter error, got %v", hash, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Login throttling defaults, each overridable through the environment:
+//
+//	LOGIN_LOCKOUT_THRESHOLD  failed attempts before the account is locked (0 disables throttling)
+//	LOGIN_BACKOFF_BASE       delay after the first failure, doubled for every further failure below the threshold
+//	LOGIN_LOCKOUT_DURATION   lock duration once the threshold is reached, doubled for every further failure
+//	LOGIN_LOCKOUT_MAX        upper bound for both delays
+const (
+	defaultLockoutThreshold = 5
+	defaultBackoffBase      = time.Second
+	defaultLockoutDuration  = 15 * time.Minute
+	defaultLockoutMax       = 24 * time.Hour
+)
+
+func LockoutThreshold() int {
+	return intFromEnv("LOGIN_LOCKOUT_THRESHOLD", defaultLockoutThreshold)
+}
+
+// IsLockedOut reports whether the number of failures has reached the lockout threshold
+func IsLockedOut(failedAttempts int) bool {
+	threshold := LockoutThreshold()
+	return threshold > 0 && failedAttempts >= threshold
+}
+
+// LoginRetryAt returns the earliest time another password attempt is accepted after the given number of
+// consecutive failures, the last of which happened at lastFailure
+func LoginRetryAt(failedAttempts int, lastFailure time.Time) time.Time {
+	threshold := LockoutThreshold()
+	if threshold == 0 || failedAttempts <= 0 {
+		return time.Time{}
+	}
+
+	var delay time.Duration
+	if failedAttempts < threshold {
+		delay = exponentialDelay(durationFromEnv("LOGIN_BACKOFF_BASE", defaultBackoffBase), failedAttempts-1)
+	} else {
+		delay = exponentialDelay(durationFromEnv("LOGIN_LOCKOUT_DURATION", defaultLockoutDuration), failedAttempts-threshold)
+	}
+
+	return lastFailure.Add(delay)
+}
+
+func exponentialDelay(base time.Duration, exponent int) time.Duration {
+	maxDelay := durationFromEnv("LOGIN_LOCKOUT_MAX", defaultLockoutMax)
+
+	delay := base
+	for i := 0; i < exponent && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func intFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return fallback
+	}
+	return number
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return fallback
+	}
+	return duration
+}
+
+// UnknownUserLockoutStore records failed logins for usernames that do not exist, so they are throttled exactly
+// like real accounts and a back-off or lockout response does not prove that an account exists
+type UnknownUserLockoutStore interface {
+	// RecordFailure counts a failed attempt the same way mongodb.RecordFailedLogin does for real accounts and
+	// returns the failed attempts and earliest retry time. While the username is still backing off or locked
+	// nothing is counted, counted is false and the current state is returned
+	RecordFailure(ctx context.Context, username string) (failedAttempts int, retryAt time.Time, counted bool, err error)
+}
+
+// FailedLoginsExpireAt returns when failed logins are forgotten, for real accounts and unknown usernames alike:
+// once the back-off or lockout is over and nothing has happened for the maximum lockout duration. The next
+// failure after that counts as the first
+func FailedLoginsExpireAt(lastFailure time.Time) time.Time {
+	return lastFailure.Add(FailedLoginsExpireAfter())
+}
+
+// FailedLoginsExpireAfter is how long failed logins are remembered after the last one (LOGIN_LOCKOUT_MAX)
+func FailedLoginsExpireAfter() time.Duration {
+	return durationFromEnv("LOGIN_LOCKOUT_MAX", defaultLockoutMax)
+}
+
+type unknownUserAttempts struct {
+	failedAttempts int
+	retryAt        time.Time
+	lastFailure    time.Time
+}
+
+// MemoryUnknownUserLockouts keeps failed logins for unknown usernames in process memory, for tests and single
+// replica setups. It holds at most maxEntries usernames and makes room by forgetting the least recent failure
+type MemoryUnknownUserLockouts struct {
+	mu         sync.Mutex
+	attempts   map[string]*unknownUserAttempts
+	maxEntries int
+}
+
+func NewMemoryUnknownUserLockouts(maxEntries int) *MemoryUnknownUserLockouts {
+	return &MemoryUnknownUserLockouts{
+		attempts:   make(map[string]*unknownUserAttempts),
+		maxEntries: maxEntries,
+	}
+}
+
+func (store *MemoryUnknownUserLockouts) RecordFailure(ctx context.Context, username string) (int, time.Time, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	attempts, ok := store.attempts[username]
+	if ok && now.After(FailedLoginsExpireAt(attempts.lastFailure)) {
+		delete(store.attempts, username)
+		ok = false
+	}
+	if !ok {
+		store.makeRoom(now)
+		attempts = &unknownUserAttempts{}
+		store.attempts[username] = attempts
+	}
+
+	if now.Before(attempts.retryAt) {
+		return attempts.failedAttempts, attempts.retryAt, false, nil
+	}
+
+	attempts.failedAttempts++
+	attempts.lastFailure = now
+	attempts.retryAt = LoginRetryAt(attempts.failedAttempts, now)
+
+	return attempts.failedAttempts, attempts.retryAt, true, nil
+}
+
+// makeRoom drops expired usernames once the store is full, then the least recent failure if that was not enough
+func (store *MemoryUnknownUserLockouts) makeRoom(now time.Time) {
+	if len(store.attempts) < store.maxEntries {
+		return
+	}
+
+	var oldestUsername string
+	var oldest time.Time
+	for username, attempts := range store.attempts {
+		if now.After(FailedLoginsExpireAt(attempts.lastFailure)) {
+			delete(store.attempts, username)
+			continue
+		}
+		if oldestUsername == "" || attempts.lastFailure.Before(oldest) {
+			oldestUsername, oldest = username, attempts.lastFailure
+		}
+	}
+
+	if len(store.attempts) >= store.maxEntries {
+		delete(store.attempts, oldestUsername)
+	}
+}
+
+// Len returns the number of usernames currently remembered
+func (store *MemoryUnknownUserLockouts) Len() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return len(store.attempts)
+}
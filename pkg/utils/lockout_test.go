@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestMemoryUnknownUserLockoutsIsBounded(t *testing.T) {
+	t.Setenv("LOGIN_LOCKOUT_THRESHOLD", "3")
+	t.Setenv("LOGIN_BACKOFF_BASE", "0s")
+
+	store := NewMemoryUnknownUserLockouts(10)
+	for i := 0; i < 100; i++ {
+		_, _, _, err := store.RecordFailure(context.Background(), fmt.Sprintf("user%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if store.Len() != 10 {
+		t.Fatalf("store holds %d usernames, want 10", store.Len())
+	}
+
+	// The most recent usernames are kept
+	failedAttempts, _, counted, _ := store.RecordFailure(context.Background(), "user99")
+	if !counted || failedAttempts != 2 {
+		t.Fatalf("user99 has %d failed attempts (counted %v), want 2", failedAttempts, counted)
+	}
+}
+
+func TestMemoryUnknownUserLockoutsStopsCountingWhileLocked(t *testing.T) {
+	t.Setenv("LOGIN_LOCKOUT_THRESHOLD", "2")
+	t.Setenv("LOGIN_BACKOFF_BASE", "0s")
+	t.Setenv("LOGIN_LOCKOUT_DURATION", "1h")
+
+	store := NewMemoryUnknownUserLockouts(10)
+	for i := 0; i < 2; i++ {
+		_, _, _, _ = store.RecordFailure(context.Background(), "nobody")
+	}
+
+	failedAttempts, retryAt, counted, _ := store.RecordFailure(context.Background(), "nobody")
+	if counted || failedAttempts != 2 || retryAt.IsZero() {
+		t.Fatalf("locked username got %d failed attempts (counted %v, retry at %v)", failedAttempts, counted, retryAt)
+	}
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// How long an emailed login code or magic link stays valid
+	LoginCodeExpiresIn = 10 * time.Minute
+	// Wrong guesses allowed before the code is burned and a new one must be requested
+	LoginCodeMaxAttempts = 5
+)
+
+// Login code sending limits, each overridable through the environment:
+//
+//	LOGIN_CODE_LIMIT_WINDOW       period the limit below applies to
+//	LOGIN_CODE_LIMIT_PER_ACCOUNT  codes and magic links an account can be sent per window (0 disables the limit)
+const (
+	defaultLoginCodeLimitWindow     = time.Hour
+	defaultLoginCodeLimitPerAccount = 5
+)
+
+func LoginCodeLimitWindow() time.Duration {
+	return durationFromEnv("LOGIN_CODE_LIMIT_WINDOW", defaultLoginCodeLimitWindow)
+}
+
+func LoginCodeLimitPerAccount() int {
+	return intFromEnv("LOGIN_CODE_LIMIT_PER_ACCOUNT", defaultLoginCodeLimitPerAccount)
+}
+
+// GenerateLoginCode returns a random 6 digit numeric code
+func GenerateLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", errors.New("failed to generate login code")
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// MagicLink builds the URL emailed to the user. The signed token carries the code, so following
+// the link is equivalent to typing the code. Requires MAGIC_LINK_URL (e.g. https://app.example.com/login/magic)
+func MagicLink(userId, code string) (string, error) {
+	err := MagicLinksConfigured()
+	if err != nil {
+		return "", err
+	}
+
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	claims := jwt.MapClaims{
+		"uid":  userId,
+		"code": code,
+		"typ":  "magic_link",
+		"exp":  jwt.NewNumericDate(time.Now().Add(LoginCodeExpiresIn)),
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtSecret))
+	if err != nil {
+		return "", err
+	}
+
+	link, _ := url.Parse(os.Getenv("MAGIC_LINK_URL"))
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
+
+// ParseMagicLinkToken validates the token from a magic link and returns the user id and code it carries
+func ParseMagicLinkToken(tokenStr string) (string, string, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", "", errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	parsedToken, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
+		return []byte(jwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !parsedToken.Valid {
+		return "", "", errors.New("invalid or expired magic link")
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "magic_link" {
+		return "", "", errors.New("invalid magic link")
+	}
+
+	userId, _ := claims["uid"].(string)
+	code, _ := claims["code"].(string)
+	if userId == "" || code == "" {
+		return "", "", errors.New("invalid magic link")
+	}
+
+	return userId, code, nil
+}
+
+// MagicLinksConfigured returns an error unless MAGIC_LINK_URL is set to a valid URL
+func MagicLinksConfigured() error {
+	baseUrl := os.Getenv("MAGIC_LINK_URL")
+	if baseUrl == "" {
+		return errors.New("MAGIC_LINK_URL environment variable is not set")
+	}
+
+	_, err := url.Parse(baseUrl)
+	if err != nil {
+		return errors.New("invalid MAGIC_LINK_URL")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Notifier delivers messages such as login codes and security alerts to users
+type Notifier interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// NewNotifier returns an SMTP notifier when SMTP_HOST is set, otherwise messages are only logged (development)
+func NewNotifier() Notifier {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogNotifier{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return SMTPNotifier{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+// SMTPNotifier sends plain text emails
+type SMTPNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (n SMTPNotifier) Send(ctx context.Context, to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	message := "From: " + n.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body + "\r\n"
+
+	err := smtp.SendMail(n.Host+":"+n.Port, auth, n.From, []string{to}, []byte(message))
+	if err != nil {
+		return ErrorHandler(err, "Error sending email")
+	}
+	return nil
+}
+
+// LogNotifier prints messages instead of sending them
+type LogNotifier struct{}
+
+func (LogNotifier) Send(ctx context.Context, to, subject, body string) error {
+	log.Printf("NOTIFY %s: %s\n%s\n", to, subject, body)
+	return nil
+}
+
+// NotifyAsync sends a message in the background so the caller's response time does not
+// depend on whether a message was sent (and the mail server's latency)
+func NotifyAsync(to, subject, body string) {
+	go func() {
+		err := NewNotifier().Send(context.Background(), to, subject, body)
+		if err != nil {
+			ErrorHandler(err, "Error delivering notification")
+		}
+	}()
+}
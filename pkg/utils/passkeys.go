@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// How long a client has to complete a passkey ceremony
+const passkeyCeremonyTimeout = 5 * time.Minute
+
+// NewWebAuthn builds the relying party configuration from WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and WEBAUTHN_RP_ORIGINS
+func NewWebAuthn() (*webauthn.WebAuthn, error) {
+	rpId := os.Getenv("WEBAUTHN_RP_ID")
+	if rpId == "" {
+		return nil, errors.New("WEBAUTHN_RP_ID environment variable is not set")
+	}
+
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "goAuth"
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{"https://" + rpId}
+	}
+
+	timeout := webauthn.TimeoutConfig{
+		Enforce:    true,
+		Timeout:    passkeyCeremonyTimeout,
+		TimeoutUVD: passkeyCeremonyTimeout,
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpId,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+}
+
+// decoyCredentialIdLength is a common length for the credential ids passkey providers issue
+const decoyCredentialIdLength = 16
+
+// DecoyCredentialId derives a stable, secret credential id for a username that has no passkeys, so answering
+// BeginPasskeyLogin for it looks the same as for a real user
+func DecoyCredentialId(username string) ([]byte, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return nil, errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	mac.Write([]byte("passkey-decoy:" + username))
+	return mac.Sum(nil)[:decoyCredentialIdLength], nil
+}
+
+// PasskeySession is the server side state kept between the Begin and Finish steps of a ceremony
+type PasskeySession struct {
+	// Empty for discoverable logins where the user is only known once the authenticator answers
+	UserId string
+	Data   webauthn.SessionData
+}
+
+// Acts as an in memory database for pending passkey ceremonies, keyed by an opaque session id
+type PasskeySessionStore struct {
+	mu       sync.Mutex
+	Sessions map[string]PasskeySession
+}
+
+// Add stores the session and returns the id the client has to send back with the Finish request
+func (store *PasskeySessionStore) Add(session PasskeySession) (string, error) {
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", errors.New("failed to generate session id")
+	}
+	sessionId := base64.RawURLEncoding.EncodeToString(raw)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.Sessions[sessionId] = session
+
+	return sessionId, nil
+}
+
+// Take removes and returns a session, so every challenge can be answered only once
+func (store *PasskeySessionStore) Take(sessionId string) (PasskeySession, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	session, ok := store.Sessions[sessionId]
+	if !ok {
+		return PasskeySession{}, false
+	}
+	delete(store.Sessions, sessionId)
+
+	if time.Now().After(session.Data.Expires) {
+		return PasskeySession{}, false
+	}
+	return session, true
+}
+
+func (store *PasskeySessionStore) CleanUpExpiredSessions() {
+	for {
+		time.Sleep(2 * time.Minute)
+
+		store.mu.Lock()
+		for sessionId, session := range store.Sessions {
+			if time.Now().After(session.Data.Expires) {
+				delete(store.Sessions, sessionId)
+			}
+		}
+		store.mu.Unlock()
+	}
+}
+
+var PasskeySessions = PasskeySessionStore{
+	Sessions: make(map[string]PasskeySession),
+}
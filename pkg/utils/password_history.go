@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+)
+
+const defaultPasswordHistorySize = 5
+
+// PasswordHistorySize returns how many of the most recent passwords (including the current one)
+// may not be reused, configured through PASSWORD_HISTORY_SIZE. Zero disables the check
+func PasswordHistorySize() int {
+	value := os.Getenv("PASSWORD_HISTORY_SIZE")
+	if value == "" {
+		return defaultPasswordHistorySize
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		return defaultPasswordHistorySize
+	}
+	return size
+}
+
+// IsPasswordReused reports whether the password matches the current hash or one of the
+// previous hashes, looking at no more than historySize passwords in total
+func IsPasswordReused(password, currentHash string, history []string, historySize int) bool {
+	if historySize <= 0 {
+		return false
+	}
+
+	hashes := append([]string{currentHash}, history...)
+	if len(hashes) > historySize {
+		hashes = hashes[:historySize]
+	}
+
+	for _, hash := range hashes {
+		if hash != "" && VerifyPassword(password, hash) == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// How long an emailed password reset token stays valid
+const PasswordResetExpiresIn = 30 * time.Minute
+
+// SignPasswordResetToken returns the token emailed for a password reset. It is bound to the current password
+// hash, so it stops working once any password change, including the reset itself, has gone through
+func SignPasswordResetToken(userId, passwordHash string) (string, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	claims := jwt.MapClaims{
+		"uid": userId,
+		"pwd": passwordFingerprint(passwordHash),
+		"typ": "password_reset",
+		"exp": jwt.NewNumericDate(time.Now().Add(PasswordResetExpiresIn)),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtSecret))
+}
+
+// ParsePasswordResetToken validates a reset token and returns the user id and password fingerprint it carries,
+// see PasswordResetTokenCurrent
+func ParsePasswordResetToken(tokenStr string) (string, string, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", "", errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	parsedToken, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
+		return []byte(jwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !parsedToken.Valid {
+		return "", "", errors.New("invalid or expired reset token")
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "password_reset" {
+		return "", "", errors.New("invalid reset token")
+	}
+
+	userId, _ := claims["uid"].(string)
+	fingerprint, _ := claims["pwd"].(string)
+	if userId == "" || fingerprint == "" {
+		return "", "", errors.New("invalid reset token")
+	}
+
+	return userId, fingerprint, nil
+}
+
+// PasswordResetTokenCurrent reports whether a reset token's fingerprint still matches the user's password hash
+func PasswordResetTokenCurrent(fingerprint, passwordHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(fingerprint), []byte(passwordFingerprint(passwordHash))) == 1
+}
+
+func passwordFingerprint(passwordHash string) string {
+	sum := sha256.Sum256([]byte(passwordHash))
+	return hex.EncodeToString(sum[:16])
+}
+
+// PasswordResetLink builds the URL emailed for a reset from PASSWORD_RESET_URL
+// (e.g. https://app.example.com/password/reset). Without it the token is sent on its own
+func PasswordResetLink(token string) string {
+	baseUrl := os.Getenv("PASSWORD_RESET_URL")
+	if baseUrl == "" {
+		return token
+	}
+
+	link, err := url.Parse(baseUrl)
+	if err != nil {
+		ErrorHandler(err, "Invalid PASSWORD_RESET_URL")
+		return token
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String()
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"errors"
+)
+
+// Permissions checked by the handlers. Roles grant them, see RoleDefinition
+const (
+	PermAccountExport     = "account.export"
+	PermAccountDelete     = "account.delete"
+	PermUsersRead         = "users.read"
+	PermUsersUnlock       = "users.unlock"
+	PermUsersSuspend      = "users.suspend"
+	PermUsersDelete       = "users.delete"
+	PermUsersRoleWrite    = "users.role.write"
+	PermUsersManageAdmins = "users.manage_admins"
+	PermAuditRead         = "audit.read"
+	PermRolesRead         = "roles.read"
+	PermRolesWrite        = "roles.write"
+	PermAccessCheck       = "access.check"
+	PermRelationsRead     = "relations.read"
+	PermRelationsWrite    = "relations.write"
+	PermOrgsCreate        = "organizations.create"
+	PermOrgMembersWrite   = "organizations.members.write"
+)
+
+// AllPermissions lists every permission that can be granted to a role
+var AllPermissions = []string{
+	PermAccountExport,
+	PermAccountDelete,
+	PermUsersRead,
+	PermUsersUnlock,
+	PermUsersSuspend,
+	PermUsersDelete,
+	PermUsersRoleWrite,
+	PermUsersManageAdmins,
+	PermAuditRead,
+	PermRolesRead,
+	PermRolesWrite,
+	PermAccessCheck,
+	PermRelationsRead,
+	PermRelationsWrite,
+	PermOrgsCreate,
+	PermOrgMembersWrite,
+}
+
+// IsKnownPermission reports whether a permission is in AllPermissions
+func IsKnownPermission(permission string) bool {
+	for _, known := range AllPermissions {
+		if known == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission checks that one of the caller's roles grants a permission, directly or through inheritance
+func RequirePermission(ctx context.Context, permission string) error {
+	roles, ok := CallerRoles(ctx)
+	if !ok {
+		return errors.New("user not authorized for access: role not found")
+	}
+
+	for _, role := range roles {
+		if RoleRegistry.HasPermission(role, permission) {
+			return nil
+		}
+	}
+	return errors.New("user not authorized for access: missing permission " + permission)
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitRule is a token bucket: Rate tokens are added per second up to Burst, each request takes one
+type RateLimitRule struct {
+	Rate  float64
+	Burst int
+	// KeyBy selects the bucket: "ip", "user" (falls back to ip for anonymous calls) or "ip+user"
+	KeyBy string
+}
+
+// RateLimiter is the storage backend for token buckets
+type RateLimiter interface {
+	// Allow takes a token from the bucket identified by key, returning how long to wait when none is left
+	Allow(ctx context.Context, key string, rule RateLimitRule) (bool, time.Duration, error)
+}
+
+// DefaultRateLimits protects the endpoints that can be called without a token
+var DefaultRateLimits = map[string]RateLimitRule{
+	"/main.AuthService/Login":                {Rate: 10.0 / 60, Burst: 10, KeyBy: "ip"},
+	"/main.AuthService/Register":             {Rate: 5.0 / 60, Burst: 5, KeyBy: "ip"},
+	"/main.AuthService/GoogleLogin":          {Rate: 10.0 / 60, Burst: 10, KeyBy: "ip"},
+	"/main.AuthService/VerifyMfa":            {Rate: 10.0 / 60, Burst: 10, KeyBy: "ip"},
+	"/main.AuthService/BeginPasskeyLogin":    {Rate: 20.0 / 60, Burst: 20, KeyBy: "ip"},
+	"/main.AuthService/FinishPasskeyLogin":   {Rate: 20.0 / 60, Burst: 20, KeyBy: "ip"},
+	"/main.AuthService/RequestLoginCode":     {Rate: 5.0 / 60, Burst: 5, KeyBy: "ip"},
+	"/main.AuthService/LoginWithCode":        {Rate: 10.0 / 60, Burst: 10, KeyBy: "ip"},
+	"/main.AuthService/AcceptInvitation":     {Rate: 10.0 / 60, Burst: 10, KeyBy: "ip"},
+	"/main.AuthService/RequestPasswordReset": {Rate: 5.0 / 60, Burst: 5, KeyBy: "ip"},
+	"/main.AuthService/ResetPassword":        {Rate: 10.0 / 60, Burst: 10, KeyBy: "ip"},
+}
+
+// ParseRateLimits reads rules in the form
+//
+//	<full method or *>=<count>/<s|m|h>:<burst>:<ip|user|ip+user>;...
+//
+// e.g. "/main.AuthService/Login=5/m:10:ip;*=100/s:200:user". The "*" rule applies to all other methods
+func ParseRateLimits(spec string) (map[string]RateLimitRule, error) {
+	rules := make(map[string]RateLimitRule)
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		method, ruleSpec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: expected method=rule", entry)
+		}
+
+		parts := strings.Split(ruleSpec, ":")
+		if len(parts) < 1 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid rate limit %q", entry)
+		}
+
+		countStr, unit, ok := strings.Cut(parts[0], "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: expected count/unit", entry)
+		}
+		count, err := strconv.ParseFloat(countStr, 64)
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q: bad count", entry)
+		}
+
+		var period float64
+		switch unit {
+		case "s":
+			period = 1
+		case "m":
+			period = 60
+		case "h":
+			period = 3600
+		default:
+			return nil, fmt.Errorf("invalid rate limit %q: unit must be s, m or h", entry)
+		}
+
+		rule := RateLimitRule{Rate: count / period, Burst: int(math.Ceil(count)), KeyBy: "ip"}
+		if len(parts) > 1 && parts[1] != "" {
+			rule.Burst, err = strconv.Atoi(parts[1])
+			if err != nil || rule.Burst <= 0 {
+				return nil, fmt.Errorf("invalid rate limit %q: bad burst", entry)
+			}
+		}
+		if len(parts) > 2 && parts[2] != "" {
+			rule.KeyBy = parts[2]
+			if rule.KeyBy != "ip" && rule.KeyBy != "user" && rule.KeyBy != "ip+user" {
+				return nil, fmt.Errorf("invalid rate limit %q: key must be ip, user or ip+user", entry)
+			}
+		}
+
+		rules[strings.TrimSpace(method)] = rule
+	}
+
+	return rules, nil
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Acts as an in memory token bucket store. Only suitable for a single replica
+type MemoryRateLimiter struct {
+	mu      sync.Mutex
+	Buckets map[string]*tokenBucket
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		Buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (limiter *MemoryRateLimiter) Allow(ctx context.Context, key string, rule RateLimitRule) (bool, time.Duration, error) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := limiter.Buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(rule.Burst), updated: now}
+		limiter.Buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(rule.Burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*rule.Rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rule.Rate * float64(time.Second)), nil
+	}
+
+	bucket.tokens--
+	return true, 0, nil
+}
+
+// CleanUpIdleBuckets drops buckets that have not been used for an hour; an unused bucket is full anyway
+func (limiter *MemoryRateLimiter) CleanUpIdleBuckets() {
+	for {
+		time.Sleep(2 * time.Minute)
+
+		limiter.mu.Lock()
+		for key, bucket := range limiter.Buckets {
+			if time.Since(bucket.updated) > time.Hour {
+				delete(limiter.Buckets, key)
+			}
+		}
+		limiter.mu.Unlock()
+	}
+}
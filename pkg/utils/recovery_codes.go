@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+)
+
+// Number of recovery codes handed out when TOTP is enabled or the codes are regenerated
+const RecoveryCodeCount = 10
+
+// Unambiguous lowercase alphabet (no 0/o, 1/l) so codes survive being written down
+const recoveryCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+// GenerateRecoveryCodes returns the plain codes to show the user once, and their hashes to store
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, nil, errors.New("failed to generate recovery codes")
+		}
+
+		for j := range raw {
+			raw[j] = recoveryCodeAlphabet[int(raw[j])%len(recoveryCodeAlphabet)]
+		}
+		code := string(raw[:5]) + "-" + string(raw[5:])
+
+		hash, err := HashPassword(normalizeRecoveryCode(code))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+
+	return codes, hashes, nil
+}
+
+// MatchRecoveryCode returns the stored hash that the code matches, if any. Each stored code costs one Argon2id
+// verification, which only callers past the password step of VerifyMfa can trigger
+func MatchRecoveryCode(code string, hashes []string) (string, bool) {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return "", false
+	}
+
+	for _, hash := range hashes {
+		if verifyArgon2(code, hash) == nil {
+			return hash, true
+		}
+	}
+	return "", false
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecoveryCodesAreArgon2idHashes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), RecoveryCodeCount)
+	}
+
+	for i, hash := range hashes {
+		if verifyArgon2(normalizeRecoveryCode(codes[i]), hash) != nil {
+			t.Fatalf("hash %d is not an Argon2id hash of its code", i)
+		}
+	}
+
+	// Codes are accepted however the user types them
+	matched, ok := MatchRecoveryCode(" "+strings.ToUpper(codes[3])+" ", hashes)
+	if !ok || matched != hashes[3] {
+		t.Fatal("recovery code not matched")
+	}
+	if _, ok := MatchRecoveryCode("aaaaa-aaaaa", hashes); ok {
+		t.Fatal("unknown recovery code matched")
+	}
+}
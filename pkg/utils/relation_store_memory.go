@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type tupleRecord struct {
+	tuple      RelationTuple
+	createdRev int64
+	// Zero while the tuple exists
+	deletedRev int64
+	deletedAt  time.Time
+}
+
+// MemoryTupleStore keeps relation tuples in process memory, so they are lost on restart and not shared between
+// replicas. Use the mongodb backend for anything but development
+type MemoryTupleStore struct {
+	mu        sync.RWMutex
+	revision  int64
+	writtenAt time.Time
+	// Records by object, including deleted ones still within RelationHistoryRetention
+	records map[RelationObject][]*tupleRecord
+}
+
+func NewMemoryTupleStore() *MemoryTupleStore {
+	return &MemoryTupleStore{records: make(map[RelationObject][]*tupleRecord)}
+}
+
+func (store *MemoryTupleStore) Write(ctx context.Context, writes, deletes []RelationTuple) (int64, time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.revision++
+	now := time.Now()
+	store.writtenAt = now
+
+	for _, tuple := range deletes {
+		if record := store.live(tuple); record != nil {
+			record.deletedRev = store.revision
+			record.deletedAt = now
+		}
+	}
+	for _, tuple := range writes {
+		if store.live(tuple) == nil {
+			store.records[tuple.Object] = append(store.records[tuple.Object], &tupleRecord{tuple: tuple, createdRev: store.revision})
+		}
+	}
+
+	return store.revision, now, nil
+}
+
+// live returns the record of a tuple that currently exists
+func (store *MemoryTupleStore) live(tuple RelationTuple) *tupleRecord {
+	for _, record := range store.records[tuple.Object] {
+		if record.deletedRev == 0 && record.tuple == tuple {
+			return record
+		}
+	}
+	return nil
+}
+
+func (store *MemoryTupleStore) Revision(ctx context.Context) (int64, time.Time, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.revision, store.writtenAt, nil
+}
+
+func (store *MemoryTupleStore) Read(ctx context.Context, filter TupleFilter, revision int64) ([]RelationTuple, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var tuples []RelationTuple
+	collect := func(records []*tupleRecord) {
+		for _, record := range records {
+			if record.createdRev > revision || (record.deletedRev != 0 && record.deletedRev <= revision) {
+				continue
+			}
+			if filter.Relation != "" && record.tuple.Relation != filter.Relation {
+				continue
+			}
+			tuples = append(tuples, record.tuple)
+		}
+	}
+
+	if filter.ObjectId != "" {
+		collect(store.records[RelationObject{Namespace: filter.Namespace, Id: filter.ObjectId}])
+		return tuples, nil
+	}
+	for object, records := range store.records {
+		if filter.Namespace == "" || object.Namespace == filter.Namespace {
+			collect(records)
+		}
+	}
+	return tuples, nil
+}
+
+// CleanUpDeletedTuples forgets tuples deleted longer than RelationHistoryRetention ago
+func (store *MemoryTupleStore) CleanUpDeletedTuples() {
+	for {
+		time.Sleep(10 * time.Minute)
+
+		cutoff := time.Now().Add(-RelationHistoryRetention())
+		store.mu.Lock()
+		for object, records := range store.records {
+			kept := records[:0]
+			for _, record := range records {
+				if record.deletedRev == 0 || record.deletedAt.After(cutoff) {
+					kept = append(kept, record)
+				}
+			}
+			if len(kept) == 0 {
+				delete(store.records, object)
+			} else {
+				store.records[object] = kept
+			}
+		}
+		store.mu.Unlock()
+	}
+}
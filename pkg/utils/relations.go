@@ -0,0 +1,539 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RelationObject is an object in a namespace, written namespace:id, e.g. document:readme
+type RelationObject struct {
+	Namespace string
+	Id        string
+}
+
+func (object RelationObject) String() string {
+	return object.Namespace + ":" + object.Id
+}
+
+// RelationSubject is either an object such as user:alice, or the userset of everyone with a relation on an
+// object, written group:eng#member
+type RelationSubject struct {
+	Namespace string
+	Id        string
+	Relation  string
+}
+
+func (subject RelationSubject) String() string {
+	if subject.Relation == "" {
+		return subject.Namespace + ":" + subject.Id
+	}
+	return subject.Namespace + ":" + subject.Id + "#" + subject.Relation
+}
+
+// Object returns the object the subject is or belongs to
+func (subject RelationSubject) Object() RelationObject {
+	return RelationObject{Namespace: subject.Namespace, Id: subject.Id}
+}
+
+// RelationTuple states that a subject has a relation on an object, written object#relation@subject,
+// e.g. document:readme#viewer@group:eng#member
+type RelationTuple struct {
+	Object   RelationObject
+	Relation string
+	Subject  RelationSubject
+}
+
+func (tuple RelationTuple) String() string {
+	return tuple.Object.String() + "#" + tuple.Relation + "@" + tuple.Subject.String()
+}
+
+var relationNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// ParseRelationObject reads namespace:id
+func ParseRelationObject(value string) (RelationObject, error) {
+	namespace, id, ok := strings.Cut(value, ":")
+	if !ok || !relationNamePattern.MatchString(namespace) {
+		return RelationObject{}, fmt.Errorf("invalid object %q: expected namespace:id", value)
+	}
+	if id == "" || len(id) > 256 || strings.ContainsAny(id, "#@ \t\r\n") {
+		return RelationObject{}, fmt.Errorf("invalid object id in %q", value)
+	}
+	return RelationObject{Namespace: namespace, Id: id}, nil
+}
+
+// ParseRelationSubject reads namespace:id or namespace:id#relation
+func ParseRelationSubject(value string) (RelationSubject, error) {
+	objectPart, relation, hasRelation := strings.Cut(value, "#")
+	object, err := ParseRelationObject(objectPart)
+	if err != nil {
+		return RelationSubject{}, fmt.Errorf("invalid subject %q: %w", value, err)
+	}
+	if hasRelation && !relationNamePattern.MatchString(relation) {
+		return RelationSubject{}, fmt.Errorf("invalid subject %q: bad relation", value)
+	}
+	return RelationSubject{Namespace: object.Namespace, Id: object.Id, Relation: relation}, nil
+}
+
+// ParseRelationTuple reads object#relation@subject
+func ParseRelationTuple(value string) (RelationTuple, error) {
+	objectRelation, subjectPart, ok := strings.Cut(value, "@")
+	if !ok {
+		return RelationTuple{}, fmt.Errorf("invalid tuple %q: expected object#relation@subject", value)
+	}
+	objectPart, relation, ok := strings.Cut(objectRelation, "#")
+	if !ok || !relationNamePattern.MatchString(relation) {
+		return RelationTuple{}, fmt.Errorf("invalid tuple %q: expected object#relation@subject", value)
+	}
+
+	object, err := ParseRelationObject(objectPart)
+	if err != nil {
+		return RelationTuple{}, fmt.Errorf("invalid tuple %q: %w", value, err)
+	}
+	subject, err := ParseRelationSubject(subjectPart)
+	if err != nil {
+		return RelationTuple{}, fmt.Errorf("invalid tuple %q: %w", value, err)
+	}
+
+	return RelationTuple{Object: object, Relation: relation, Subject: subject}, nil
+}
+
+// RelationSchema declares the namespaces objects can live in and the relations they support
+type RelationSchema struct {
+	Namespaces map[string]NamespaceDefinition `yaml:"namespaces"`
+}
+
+// NamespaceDefinition lists the relations of a namespace. Namespaces such as user need none
+type NamespaceDefinition struct {
+	Relations map[string]RelationDefinition `yaml:"relations"`
+}
+
+// RelationDefinition says who has a relation besides the subjects of its own tuples
+type RelationDefinition struct {
+	// Other relations of the same object that imply this one, e.g. editors are also viewers
+	ComputedUsersets []string `yaml:"computed_usersets"`
+	// Relations inherited through a related object, e.g. viewers of a document's parent folder
+	TupleToUsersets []TupleToUserset `yaml:"tuple_to_usersets"`
+}
+
+// TupleToUserset follows the Tupleset relation to other objects and includes their ComputedUserset
+type TupleToUserset struct {
+	Tupleset        string `yaml:"tupleset"`
+	ComputedUserset string `yaml:"computed_userset"`
+}
+
+// Validate checks that names are well formed and that rewrites only refer to relations of the same namespace
+func (schema *RelationSchema) Validate() error {
+	var problems []string
+	for namespaceName, namespace := range schema.Namespaces {
+		if !relationNamePattern.MatchString(namespaceName) {
+			problems = append(problems, fmt.Sprintf("invalid namespace name %q", namespaceName))
+		}
+		for relationName, relation := range namespace.Relations {
+			label := namespaceName + "#" + relationName
+			if !relationNamePattern.MatchString(relationName) {
+				problems = append(problems, fmt.Sprintf("invalid relation name %q", label))
+			}
+			for _, computed := range relation.ComputedUsersets {
+				if _, ok := namespace.Relations[computed]; !ok {
+					problems = append(problems, fmt.Sprintf("%s refers to unknown relation %q", label, computed))
+				}
+			}
+			for _, tupleToUserset := range relation.TupleToUsersets {
+				if _, ok := namespace.Relations[tupleToUserset.Tupleset]; !ok {
+					problems = append(problems, fmt.Sprintf("%s refers to unknown tupleset %q", label, tupleToUserset.Tupleset))
+				}
+				if !relationNamePattern.MatchString(tupleToUserset.ComputedUserset) {
+					problems = append(problems, fmt.Sprintf("%s has an invalid computed userset %q", label, tupleToUserset.ComputedUserset))
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid relation schema:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Relation returns the definition of a relation
+func (schema *RelationSchema) Relation(namespace, relation string) (RelationDefinition, bool) {
+	definition, ok := schema.Namespaces[namespace].Relations[relation]
+	return definition, ok
+}
+
+// ValidateTuple makes sure a tuple only uses namespaces and relations the schema declares
+func (schema *RelationSchema) ValidateTuple(tuple RelationTuple) error {
+	if _, ok := schema.Relation(tuple.Object.Namespace, tuple.Relation); !ok {
+		return fmt.Errorf("%s: namespace %q has no relation %q", tuple, tuple.Object.Namespace, tuple.Relation)
+	}
+	return schema.ValidateSubject(tuple.Subject)
+}
+
+// ValidateSubject makes sure a subject's namespace, and relation if it is a userset, exist
+func (schema *RelationSchema) ValidateSubject(subject RelationSubject) error {
+	if _, ok := schema.Namespaces[subject.Namespace]; !ok {
+		return fmt.Errorf("%s: unknown namespace %q", subject, subject.Namespace)
+	}
+	if subject.Relation != "" {
+		if _, ok := schema.Relation(subject.Namespace, subject.Relation); !ok {
+			return fmt.Errorf("%s: namespace %q has no relation %q", subject, subject.Namespace, subject.Relation)
+		}
+	}
+	return nil
+}
+
+// TupleFilter selects tuples by object and relation, empty fields match everything
+type TupleFilter struct {
+	Namespace string
+	ObjectId  string
+	Relation  string
+}
+
+// TupleStore is the storage backend for relation tuples. Every write creates a new revision and deleted tuples
+// are kept for RelationHistoryRetention, so reads can be evaluated as of an earlier revision
+type TupleStore interface {
+	// Write applies the writes and deletes as one revision and returns it with the time it was written. The
+	// revision is only returned by Revision, and so readable, once all of its changes are in place. Writing
+	// a tuple that exists or deleting one that does not is not an error
+	Write(ctx context.Context, writes, deletes []RelationTuple) (int64, time.Time, error)
+	// Revision returns the latest committed revision and the time it was written, zero before the first write
+	Revision(ctx context.Context) (int64, time.Time, error)
+	// Read returns the tuples matching the filter as of a revision
+	Read(ctx context.Context, filter TupleFilter, revision int64) ([]RelationTuple, error)
+}
+
+// RelationHistoryRetention is how long deleted tuples are kept, and so how old a consistency token can be when
+// reading at its exact snapshot (RELATION_HISTORY_RETENTION, 24 hours by default)
+func RelationHistoryRetention() time.Duration {
+	return durationFromEnv("RELATION_HISTORY_RETENTION", 24*time.Hour)
+}
+
+var (
+	ErrInvalidConsistencyToken = errors.New("invalid consistency token")
+	ErrConsistencyTokenAhead   = errors.New("consistency token is newer than the tuple store")
+	ErrSnapshotExpired         = errors.New("consistency token is too old to read at its exact snapshot")
+	ErrRelationDepth           = errors.New("relation graph is too deep")
+)
+
+// EncodeConsistencyToken returns the opaque token handed out for a revision. It carries the time the revision
+// was written, which decides how long it can be read at its exact snapshot, and is signed with JWT_SECRET so
+// clients cannot move that time forward
+func EncodeConsistencyToken(revision int64, writtenAt time.Time) (string, error) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(revision, 10) + "." + strconv.FormatInt(writtenAt.Unix(), 10)))
+	signature, err := consistencyTokenSignature(payload)
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + signature, nil
+}
+
+// DecodeConsistencyToken checks a token's signature and returns its revision and when that revision was written
+func DecodeConsistencyToken(token string) (int64, time.Time, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, time.Time{}, ErrInvalidConsistencyToken
+	}
+	expected, err := consistencyTokenSignature(payload)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return 0, time.Time{}, ErrInvalidConsistencyToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return 0, time.Time{}, ErrInvalidConsistencyToken
+	}
+	revisionPart, writtenPart, ok := strings.Cut(string(data), ".")
+	if !ok {
+		return 0, time.Time{}, ErrInvalidConsistencyToken
+	}
+	revision, err := strconv.ParseInt(revisionPart, 10, 64)
+	if err != nil || revision < 0 {
+		return 0, time.Time{}, ErrInvalidConsistencyToken
+	}
+	writtenAt, err := strconv.ParseInt(writtenPart, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, ErrInvalidConsistencyToken
+	}
+	return revision, time.Unix(writtenAt, 0), nil
+}
+
+func consistencyTokenSignature(payload string) (string, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", errors.New("JWT_SECRET environment variable is not set")
+	}
+
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	mac.Write([]byte("consistency_token." + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Limits on the work a single request can cause
+const (
+	maxRelationDepth = 25
+	MaxListObjects   = 1000
+	// Objects ListObjects checks before giving up on finding more
+	maxListObjectCandidates = 10000
+)
+
+// RelationEngine evaluates relations using a schema and a tuple store
+type RelationEngine struct {
+	Schema *RelationSchema
+	Store  TupleStore
+}
+
+func NewRelationEngine(schema *RelationSchema, store TupleStore) *RelationEngine {
+	return &RelationEngine{Schema: schema, Store: store}
+}
+
+// Snapshot picks the revision a read is evaluated at and returns it with the time it was written. Without a token
+// that is the latest revision. With one it is the latest revision too, which always includes the writes the token
+// was issued for, or exactly the token's revision when exact is set
+func (engine *RelationEngine) Snapshot(ctx context.Context, token string, exact bool) (int64, time.Time, error) {
+	current, currentWrittenAt, err := engine.Store.Revision(ctx)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if token == "" {
+		if exact {
+			return 0, time.Time{}, ErrInvalidConsistencyToken
+		}
+		return current, currentWrittenAt, nil
+	}
+
+	revision, writtenAt, err := DecodeConsistencyToken(token)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if revision > current {
+		return 0, time.Time{}, ErrConsistencyTokenAhead
+	}
+	if !exact {
+		return current, currentWrittenAt, nil
+	}
+	// Tuples deleted after the revision was written are kept for RelationHistoryRetention from their deletion,
+	// so all of them are still there until that long after the revision itself. Nothing predates revision 0
+	if revision > 0 && time.Since(writtenAt) > RelationHistoryRetention() {
+		return 0, time.Time{}, ErrSnapshotExpired
+	}
+	return revision, writtenAt, nil
+}
+
+// relationNode is an object's relation, the unit check and expand visit once per request
+type relationNode struct {
+	object   RelationObject
+	relation string
+}
+
+// Check reports whether the subject has the relation on the object, directly, through a userset, a computed
+// userset or a tuple to userset rewrite
+func (engine *RelationEngine) Check(ctx context.Context, object RelationObject, relation string, subject RelationSubject, revision int64) (bool, error) {
+	return engine.check(ctx, object, relation, subject, revision, make(map[relationNode]bool), 0)
+}
+
+// check skips relations it has visited before: they are either still being checked further up, which makes a
+// cycle, or were checked already without finding the subject
+func (engine *RelationEngine) check(ctx context.Context, object RelationObject, relation string, subject RelationSubject, revision int64, visited map[relationNode]bool, depth int) (bool, error) {
+	if depth > maxRelationDepth {
+		return false, ErrRelationDepth
+	}
+	definition, ok := engine.Schema.Relation(object.Namespace, relation)
+	if !ok {
+		return false, nil
+	}
+	if subject.Relation == relation && subject.Object() == object {
+		return true, nil
+	}
+	node := relationNode{object: object, relation: relation}
+	if visited[node] {
+		return false, nil
+	}
+	visited[node] = true
+
+	tuples, err := engine.Store.Read(ctx, TupleFilter{Namespace: object.Namespace, ObjectId: object.Id, Relation: relation}, revision)
+	if err != nil {
+		return false, err
+	}
+	for _, tuple := range tuples {
+		if tuple.Subject == subject {
+			return true, nil
+		}
+	}
+	for _, tuple := range tuples {
+		if tuple.Subject.Relation == "" {
+			continue
+		}
+		found, err := engine.check(ctx, tuple.Subject.Object(), tuple.Subject.Relation, subject, revision, visited, depth+1)
+		if err != nil || found {
+			return found, err
+		}
+	}
+
+	for _, computed := range definition.ComputedUsersets {
+		found, err := engine.check(ctx, object, computed, subject, revision, visited, depth+1)
+		if err != nil || found {
+			return found, err
+		}
+	}
+
+	for _, tupleToUserset := range definition.TupleToUsersets {
+		related, err := engine.Store.Read(ctx, TupleFilter{Namespace: object.Namespace, ObjectId: object.Id, Relation: tupleToUserset.Tupleset}, revision)
+		if err != nil {
+			return false, err
+		}
+		for _, tuple := range related {
+			found, err := engine.check(ctx, tuple.Subject.Object(), tupleToUserset.ComputedUserset, subject, revision, visited, depth+1)
+			if err != nil || found {
+				return found, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// UsersetTree is the expansion of object#relation: its direct subjects, which may be usersets themselves, and
+// the expansions of the computed and tuple to userset relations it includes
+type UsersetTree struct {
+	Userset  string
+	Subjects []string
+	Children []*UsersetTree
+}
+
+// Expand returns the tree of everyone with the relation on the object
+func (engine *RelationEngine) Expand(ctx context.Context, object RelationObject, relation string, revision int64) (*UsersetTree, error) {
+	return engine.expand(ctx, object, relation, revision, make(map[relationNode]bool), 0)
+}
+
+// expand expands each relation once: when it comes up again, through a cycle or another path, the tree only
+// names it
+func (engine *RelationEngine) expand(ctx context.Context, object RelationObject, relation string, revision int64, visited map[relationNode]bool, depth int) (*UsersetTree, error) {
+	if depth > maxRelationDepth {
+		return nil, ErrRelationDepth
+	}
+	tree := &UsersetTree{Userset: object.String() + "#" + relation}
+	definition, ok := engine.Schema.Relation(object.Namespace, relation)
+	if !ok {
+		return tree, nil
+	}
+	node := relationNode{object: object, relation: relation}
+	if visited[node] {
+		return tree, nil
+	}
+	visited[node] = true
+
+	tuples, err := engine.Store.Read(ctx, TupleFilter{Namespace: object.Namespace, ObjectId: object.Id, Relation: relation}, revision)
+	if err != nil {
+		return nil, err
+	}
+	for _, tuple := range tuples {
+		tree.Subjects = append(tree.Subjects, tuple.Subject.String())
+	}
+	sort.Strings(tree.Subjects)
+
+	for _, computed := range definition.ComputedUsersets {
+		child, err := engine.expand(ctx, object, computed, revision, visited, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		tree.Children = append(tree.Children, child)
+	}
+
+	for _, tupleToUserset := range definition.TupleToUsersets {
+		related, err := engine.Store.Read(ctx, TupleFilter{Namespace: object.Namespace, ObjectId: object.Id, Relation: tupleToUserset.Tupleset}, revision)
+		if err != nil {
+			return nil, err
+		}
+		for _, tuple := range related {
+			child, err := engine.expand(ctx, tuple.Subject.Object(), tupleToUserset.ComputedUserset, revision, visited, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			tree.Children = append(tree.Children, child)
+		}
+	}
+
+	return tree, nil
+}
+
+// ListObjects returns the ids of the objects in a namespace the subject has the relation on, sorted and at most
+// MaxListObjects of them. Only the first maxListObjectCandidates objects of the namespace are checked
+func (engine *RelationEngine) ListObjects(ctx context.Context, namespace, relation string, subject RelationSubject, revision int64) ([]string, error) {
+	tuples, err := engine.Store.Read(ctx, TupleFilter{Namespace: namespace}, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[string]bool)
+	for _, tuple := range tuples {
+		candidates[tuple.Object.Id] = true
+	}
+	objectIds := make([]string, 0, len(candidates))
+	for objectId := range candidates {
+		objectIds = append(objectIds, objectId)
+	}
+	sort.Strings(objectIds)
+	if len(objectIds) > maxListObjectCandidates {
+		objectIds = objectIds[:maxListObjectCandidates]
+	}
+
+	var allowed []string
+	for _, objectId := range objectIds {
+		found, err := engine.Check(ctx, RelationObject{Namespace: namespace, Id: objectId}, relation, subject, revision)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			allowed = append(allowed, objectId)
+			if len(allowed) == MaxListObjects {
+				break
+			}
+		}
+	}
+	return allowed, nil
+}
+
+//go:embed relation_schema.yaml
+var defaultRelationSchema []byte
+
+// LoadRelationSchema reads the namespaces from the file named by RELATION_SCHEMA_FILE, or the built-in
+// relation_schema.yaml when it is not set
+func LoadRelationSchema() (*RelationSchema, error) {
+	data := defaultRelationSchema
+	if path := os.Getenv("RELATION_SCHEMA_FILE"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, ErrorHandler(err, "Error reading relation schema")
+		}
+	}
+
+	var schema RelationSchema
+	err := yaml.Unmarshal(data, &schema)
+	if err != nil {
+		return nil, ErrorHandler(err, "Error parsing relation schema")
+	}
+
+	err = schema.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRelationEngine(t *testing.T, tuples ...string) *RelationEngine {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	schema, err := LoadRelationSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewRelationEngine(schema, NewMemoryTupleStore())
+	if len(tuples) > 0 {
+		writeTuples(t, engine, tuples...)
+	}
+	return engine
+}
+
+func writeTuples(t *testing.T, engine *RelationEngine, values ...string) int64 {
+	t.Helper()
+
+	var writes []RelationTuple
+	for _, value := range values {
+		tuple, err := ParseRelationTuple(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writes = append(writes, tuple)
+	}
+	revision, _, err := engine.Store.Write(context.Background(), writes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return revision
+}
+
+func TestSnapshotKeepsRevisionWriteTime(t *testing.T) {
+	engine := testRelationEngine(t)
+	ctx := context.Background()
+
+	revision, writtenAt, err := engine.Store.Write(ctx, []RelationTuple{{
+		Object:   RelationObject{Namespace: "document", Id: "readme"},
+		Relation: "viewer",
+		Subject:  RelationSubject{Namespace: "user", Id: "alice"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := EncodeConsistencyToken(revision, writtenAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading at the exact snapshot hands back the write time, not the time of the read
+	_, snapshotWrittenAt, err := engine.Snapshot(ctx, token, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshotWrittenAt.Unix() != writtenAt.Unix() {
+		t.Fatalf("snapshot written at %v, want %v", snapshotWrittenAt, writtenAt)
+	}
+}
+
+func TestExactSnapshotExpiresWithRevisionAge(t *testing.T) {
+	engine := testRelationEngine(t, "document:readme#viewer@user:alice")
+	t.Setenv("RELATION_HISTORY_RETENTION", "1h")
+	ctx := context.Background()
+
+	token, err := EncodeConsistencyToken(1, time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = engine.Snapshot(ctx, token, true)
+	if !errors.Is(err, ErrSnapshotExpired) {
+		t.Fatalf("got %v, want ErrSnapshotExpired", err)
+	}
+
+	// Without exact the token is still good for reading the latest revision
+	_, _, err = engine.Snapshot(ctx, token, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsistencyTokenRejectsTampering(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	token, err := EncodeConsistencyToken(1, time.Now().Add(-48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, signature, _ := strings.Cut(token, ".")
+
+	// Moving the write time forward to extend the snapshot
+	forged := base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "1.%d", time.Now().Unix())) + "." + signature
+	_, _, err = DecodeConsistencyToken(forged)
+	if !errors.Is(err, ErrInvalidConsistencyToken) {
+		t.Fatalf("forged token: got %v, want ErrInvalidConsistencyToken", err)
+	}
+
+	t.Setenv("JWT_SECRET", "other-secret")
+	_, _, err = DecodeConsistencyToken(token)
+	if !errors.Is(err, ErrInvalidConsistencyToken) {
+		t.Fatalf("token signed with another secret: got %v, want ErrInvalidConsistencyToken", err)
+	}
+}
+
+func TestCheckFollowsCyclicUsersets(t *testing.T) {
+	engine := testRelationEngine(t,
+		"group:a#member@group:b#member",
+		"group:b#member@group:a#member",
+		"group:b#member@user:alice",
+		"document:readme#viewer@group:a#member",
+	)
+	ctx := context.Background()
+	revision, _, _ := engine.Store.Revision(ctx)
+
+	check := func(object, relation, subject string) bool {
+		t.Helper()
+		parsedObject, _ := ParseRelationObject(object)
+		parsedSubject, _ := ParseRelationSubject(subject)
+		allowed, err := engine.Check(ctx, parsedObject, relation, parsedSubject, revision)
+		if err != nil {
+			t.Fatalf("check %s#%s@%s: %v", object, relation, subject, err)
+		}
+		return allowed
+	}
+
+	if !check("group:a", "member", "user:alice") {
+		t.Fatal("alice is a member of group:a through group:b")
+	}
+	if !check("document:readme", "viewer", "user:alice") {
+		t.Fatal("alice views the document through group:a")
+	}
+	if check("group:a", "member", "user:bob") {
+		t.Fatal("bob is in neither group")
+	}
+}
+
+func TestExpandStopsAtCycles(t *testing.T) {
+	engine := testRelationEngine(t,
+		"group:a#member@group:b#member",
+		"group:b#member@group:a#member",
+		"folder:x#parent@folder:y",
+		"folder:y#parent@folder:x",
+	)
+	ctx := context.Background()
+	revision, _, _ := engine.Store.Revision(ctx)
+
+	_, err := engine.Expand(ctx, RelationObject{Namespace: "group", Id: "a"}, "member", revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := engine.Expand(ctx, RelationObject{Namespace: "folder", Id: "x"}, "viewer", revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Userset != "folder:x#viewer" {
+		t.Fatalf("unexpected tree root %q", tree.Userset)
+	}
+}
+
+func TestListObjectsCapsCandidates(t *testing.T) {
+	engine := testRelationEngine(t)
+
+	tuples := make([]string, 0, maxListObjectCandidates+1)
+	for i := 0; i < maxListObjectCandidates; i++ {
+		tuples = append(tuples, fmt.Sprintf("document:d%05d#viewer@user:bob", i))
+	}
+	// Sorts after every other candidate, so it is never checked
+	tuples = append(tuples, fmt.Sprintf("document:d%05d#viewer@user:alice", maxListObjectCandidates))
+	revision := writeTuples(t, engine, tuples...)
+
+	objectIds, err := engine.ListObjects(context.Background(), "document", "viewer", RelationSubject{Namespace: "user", Id: "alice"}, revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objectIds) != 0 {
+		t.Fatalf("checked objects past the candidate limit: %v", objectIds)
+	}
+}
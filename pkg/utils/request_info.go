@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"context"
+	"math"
+	"net"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ClientIP returns the IP address of the peer that sent the request, or an empty string if unknown
+func ClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// UserAgent returns the user-agent metadata sent by the client, or an empty string
+func UserAgent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md["user-agent"]) == 0 {
+		return ""
+	}
+	return md["user-agent"][0]
+}
+
+// RetryAfterError builds a status error carrying a google.rpc.RetryInfo detail, rounded up to whole seconds
+// so clients never retry a moment too early
+func RetryAfterError(code codes.Code, message string, retryAfter time.Duration) error {
+	retryAfter = time.Duration(math.Ceil(retryAfter.Seconds())) * time.Second
+
+	st := status.New(code, message)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,10 @@
+package utils
+
+import "time"
+
+const defaultUserPurgeAfter = 30 * 24 * time.Hour
+
+// UserPurgeAfter is how long soft deleted users are kept before they are removed for good (USER_PURGE_AFTER)
+func UserPurgeAfter() time.Duration {
+	return durationFromEnv("USER_PURGE_AFTER", defaultUserPurgeAfter)
+}
@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Built-in role names
+const (
+	RoleUser       = "user"
+	RoleAdmin      = "admin"
+	RoleSuperAdmin = "super_admin"
+)
+
+// RoleDefinition is a named set of permissions. A role also has every permission of the roles it inherits
+type RoleDefinition struct {
+	Name        string
+	Description string
+	Permissions []string
+	Inherits    []string
+	BuiltIn     bool
+}
+
+// DefaultRoles are created in the database on startup if missing: super_admin ⊇ admin ⊇ user
+var DefaultRoles = []RoleDefinition{
+	{
+		Name:        RoleUser,
+		Description: "Regular user managing their own account",
+		Permissions: []string{PermAccountExport, PermAccountDelete},
+		BuiltIn:     true,
+	},
+	{
+		Name:        RoleAdmin,
+		Description: "Manages users and reads the audit log",
+		Permissions: []string{PermUsersRead, PermUsersUnlock, PermUsersSuspend, PermUsersDelete, PermAuditRead, PermRolesRead, PermAccessCheck, PermRelationsRead, PermOrgMembersWrite},
+		Inherits:    []string{RoleUser},
+		BuiltIn:     true,
+	},
+	{
+		Name:        RoleSuperAdmin,
+		Description: "Manages roles and other admins",
+		Permissions: []string{PermUsersRoleWrite, PermUsersManageAdmins, PermRolesWrite, PermRelationsWrite, PermOrgsCreate},
+		Inherits:    []string{RoleAdmin},
+		BuiltIn:     true,
+	},
+}
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+// RoleRegistryStore holds the role definitions loaded from the database
+type RoleRegistryStore struct {
+	mu      sync.RWMutex
+	roles   map[string]RoleDefinition
+	version string
+}
+
+// Replace swaps in a freshly loaded set of roles
+func (store *RoleRegistryStore) Replace(roles []RoleDefinition) {
+	byName := make(map[string]RoleDefinition, len(roles))
+	for _, role := range roles {
+		byName[role.Name] = role
+	}
+	version := contentVersion(byName)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.roles = byName
+	store.version = version
+}
+
+// Version changes whenever the loaded roles differ from the previous ones
+func (store *RoleRegistryStore) Version() string {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.version
+}
+
+// Exists reports whether a role can be assigned to users
+func (store *RoleRegistryStore) Exists(name string) bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	_, ok := store.roles[name]
+	return ok
+}
+
+// Get returns a role definition
+func (store *RoleRegistryStore) Get(name string) (RoleDefinition, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	role, ok := store.roles[name]
+	return role, ok
+}
+
+// All returns every role sorted by name
+func (store *RoleRegistryStore) All() []RoleDefinition {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	roles := make([]RoleDefinition, 0, len(store.roles))
+	for _, role := range store.roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles
+}
+
+// Names returns every role name sorted
+func (store *RoleRegistryStore) Names() []string {
+	var names []string
+	for _, role := range store.All() {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+// EffectivePermissions returns the permissions of a role including inherited ones, sorted
+func (store *RoleRegistryStore) EffectivePermissions(name string) []string {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	granted := make(map[string]bool)
+	collectPermissions(store.roles, name, granted, make(map[string]bool))
+
+	permissions := make([]string, 0, len(granted))
+	for permission := range granted {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// HasPermission reports whether a role grants a permission, directly or through inheritance
+func (store *RoleRegistryStore) HasPermission(name, permission string) bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	granted := make(map[string]bool)
+	collectPermissions(store.roles, name, granted, make(map[string]bool))
+	return granted[permission]
+}
+
+// WithRole validates a new or changed role against the current registry: the name must be well formed,
+// permissions known, inherited roles must exist and inheritance must not loop
+func (store *RoleRegistryStore) WithRole(role RoleDefinition) error {
+	if !roleNamePattern.MatchString(role.Name) {
+		return fmt.Errorf("role name must be 2-32 lowercase letters, digits or underscores")
+	}
+
+	for _, permission := range role.Permissions {
+		if !IsKnownPermission(permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	candidate := make(map[string]RoleDefinition, len(store.roles)+1)
+	for name, existing := range store.roles {
+		candidate[name] = existing
+	}
+	candidate[role.Name] = role
+
+	for _, parent := range role.Inherits {
+		if _, ok := candidate[parent]; !ok {
+			return fmt.Errorf("inherited role %q does not exist", parent)
+		}
+	}
+
+	if inheritsFrom(candidate, role.Name, role.Name, make(map[string]bool)) {
+		return fmt.Errorf("role %q would inherit from itself", role.Name)
+	}
+
+	return nil
+}
+
+func collectPermissions(roles map[string]RoleDefinition, name string, granted, visited map[string]bool) {
+	if visited[name] {
+		return
+	}
+	visited[name] = true
+
+	role, ok := roles[name]
+	if !ok {
+		return
+	}
+	for _, permission := range role.Permissions {
+		granted[permission] = true
+	}
+	for _, parent := range role.Inherits {
+		collectPermissions(roles, parent, granted, visited)
+	}
+}
+
+func inheritsFrom(roles map[string]RoleDefinition, name, ancestor string, visited map[string]bool) bool {
+	if visited[name] {
+		return false
+	}
+	visited[name] = true
+
+	for _, parent := range roles[name].Inherits {
+		if parent == ancestor || inheritsFrom(roles, parent, ancestor, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleRefreshInterval is how often roles are reloaded from the database, so changes made through
+// another replica take effect here too (ROLE_REFRESH_INTERVAL, 30 seconds by default)
+func RoleRefreshInterval() time.Duration {
+	return durationFromEnv("ROLE_REFRESH_INTERVAL", 30*time.Second)
+}
+
+// RoleRegistry starts out with the default roles until the database has been read
+var RoleRegistry = newRoleRegistry()
+
+func newRoleRegistry() *RoleRegistryStore {
+	store := &RoleRegistryStore{}
+	store.Replace(DefaultRoles)
+	return store
+}
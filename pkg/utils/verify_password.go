@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/argon2"
 )
@@ -29,7 +30,45 @@ func HashPassword(password string) (string, error) {
 	return saltBase64 + "." + hashBase64, nil
 }
 
+// VerifyPassword checks the input password against the stored hash.
+// Hashes imported from legacy systems (bcrypt, scrypt, PBKDF2-SHA256) are recognised by their prefix
 func VerifyPassword(inputPassword, storedPassword string) error {
+	switch {
+	case isBcryptHash(storedPassword):
+		return verifyBcrypt(inputPassword, storedPassword)
+	case strings.HasPrefix(storedPassword, scryptPrefix):
+		return verifyScrypt(inputPassword, storedPassword)
+	case strings.HasPrefix(storedPassword, pbkdf2Prefix), strings.HasPrefix(storedPassword, djangoPbkdf2Prefix):
+		return verifyPbkdf2(inputPassword, storedPassword)
+	}
+
+	return verifyArgon2(inputPassword, storedPassword)
+}
+
+// dummyPasswordHash is a real Argon2id hash of a random password, computed once on first use
+var dummyPasswordHash = sync.OnceValue(func() string {
+	random := make([]byte, 32)
+	rand.Read(random)
+	hash, _ := HashPassword(base64.StdEncoding.EncodeToString(random))
+	return hash
+})
+
+// VerifyDummyPassword performs the same work as verifying a real password. It is used when there is no
+// account (or no password) to check, so the response time does not reveal that
+func VerifyDummyPassword(inputPassword string) {
+	_ = VerifyPassword(inputPassword, dummyPasswordHash())
+}
+
+// NeedsRehash reports whether the stored hash is not in the current Argon2id format
+// and should be replaced after the next successful login
+func NeedsRehash(storedPassword string) bool {
+	return isBcryptHash(storedPassword) ||
+		strings.HasPrefix(storedPassword, scryptPrefix) ||
+		strings.HasPrefix(storedPassword, pbkdf2Prefix) ||
+		strings.HasPrefix(storedPassword, djangoPbkdf2Prefix)
+}
+
+func verifyArgon2(inputPassword, storedPassword string) error {
 	parts := strings.Split(storedPassword, ".")
 	if len(parts) != 2 {
 		return errors.New("invalid encoded hash format")